@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Default pg_advisory_lock key, shared with the migrate package so the CLI and
+// programs using it serialize with each other
+const defaultAdvisoryLockKey = migrate.DefaultLockKey
+
+// A session-level advisory lock held on a dedicated connection for the whole
+// run. Unlike the lease it is released the moment the runner's session ends,
+// so a killed runner never blocks the next one.
+type advisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// Takes the advisory lock, waiting up to wait for the session holding it
+func acquireAdvisoryLock(ctx context.Context, db *sql.DB, key int64, wait time.Duration) (*advisoryLock, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&acquired); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if acquired {
+			return &advisoryLock{conn: conn, key: key}, nil
+		}
+
+		holder := advisoryLockHolder(ctx, conn, key)
+		if time.Now().After(deadline) {
+			conn.Close()
+			return nil, fmt.Errorf("advisory lock %d is held by %s", key, holder)
+		}
+		logInfo("Waiting for advisory lock %d held by %s...", key, holder)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// Describes the session holding the advisory lock, for waiting messages
+func advisoryLockHolder(ctx context.Context, conn *sql.Conn, key int64) string {
+	var pid int
+	var app, addr string
+	err := conn.QueryRowContext(ctx, `
+		SELECT l.pid, COALESCE(a.application_name, ''), COALESCE(a.client_addr::text, 'local')
+		FROM pg_locks l
+		LEFT JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted
+			AND l.classid = ($1::bigint >> 32)::oid
+			AND l.objid = ($1::bigint & 4294967295)::oid
+			AND l.objsubid = 1
+		LIMIT 1
+	`, key).Scan(&pid, &app, &addr)
+	if err != nil {
+		return "another session"
+	}
+	if app == "" {
+		return fmt.Sprintf("backend %d (%s)", pid, addr)
+	}
+	return fmt.Sprintf("backend %d (%s, %s)", pid, app, addr)
+}
+
+// Unlocks and returns the dedicated connection to the pool
+func (a *advisoryLock) release(ctx context.Context) error {
+	defer a.conn.Close()
+	_, err := a.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, a.key)
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Set for release builds with -ldflags "-X main.toolVersion=v1.2.3"
+var toolVersion = "dev"
+
+// application_name of the tool's sessions, e.g. supabase-direct-migrate/v1.2.3
+func applicationName() string {
+	return "supabase-direct-migrate/" + toolVersion
+}
+
+// Returns the connection string with application_name set to the tool's,
+// unless it already sets one
+func withApplicationName(dbURL string) string {
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		u, err := url.Parse(dbURL)
+		if err != nil {
+			return dbURL
+		}
+		q := u.Query()
+		if q.Get("application_name") != "" {
+			return dbURL
+		}
+		q.Set("application_name", applicationName())
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	// key=value connection string
+	if strings.Contains(dbURL, "application_name=") {
+		return dbURL
+	}
+	return dbURL + " application_name='" + applicationName() + "'"
+}
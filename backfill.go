@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const backfillTableName = "schema_migrations_backfills"
+
+// An UPDATE to run over a table in key-ordered batches, declared with
+// -- backfill: TABLE SET ... [WHERE ...]
+type backfillSpec struct {
+	Table string
+	Set   string
+	Where string
+}
+
+var backfillDirective = regexp.MustCompile(`(?is)^(\S+)\s+SET\s+(.+?)(?:\s+WHERE\s+(.+))?$`)
+
+func parseBackfillDirective(value string) (backfillSpec, error) {
+	match := backfillDirective.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return backfillSpec{}, fmt.Errorf("expected TABLE SET column = expression [WHERE condition], got %q", value)
+	}
+	return backfillSpec{Table: match[1], Set: match[2], Where: match[3]}, nil
+}
+
+// Creates the table that tracks backfill progress
+func ensureBackfillTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			version TEXT NOT NULL,
+			position INT NOT NULL,
+			table_name TEXT NOT NULL,
+			set_clause TEXT NOT NULL,
+			where_clause TEXT NOT NULL DEFAULT '',
+			last_key TEXT,
+			rows_done BIGINT NOT NULL DEFAULT 0,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMPTZ,
+			PRIMARY KEY (version, position)
+		)
+	`, schemaName, backfillTableName))
+	return err
+}
+
+// Records a migration's backfills as due, in the migration's transaction, so
+// they are resumed by a later run if this one stops before they finish
+func recordBackfills(ctx context.Context, ex execer, version string, specs []backfillSpec) error {
+	for i, b := range specs {
+		_, err := ex.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s.%s (version, position, table_name, set_clause, where_clause)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (version, position) DO NOTHING
+		`, schemaName, backfillTableName), version, i, b.Table, b.Set, b.Where)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs every backfill that hasn't finished, oldest first, including ones an
+// earlier run was interrupted in. version limits it to one migration's.
+func runPendingBackfills(ctx context.Context, db *sql.DB, version string, batchSize int) error {
+	if batchSize < 1 {
+		return fmt.Errorf("--backfill-batch-size must be at least 1")
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version, position, table_name, set_clause, where_clause, COALESCE(last_key, ''), last_key IS NOT NULL, rows_done
+		FROM %s.%s
+		WHERE finished_at IS NULL AND ($1 = '' OR version = $1)
+		ORDER BY version, position
+	`, schemaName, backfillTableName), version)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		version  string
+		position int
+		spec     backfillSpec
+		lastKey  string
+		resuming bool
+		done     int64
+	}
+	var due []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.version, &p.position, &p.spec.Table, &p.spec.Set, &p.spec.Where, &p.lastKey, &p.resuming, &p.done); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range due {
+		if p.resuming {
+			logInfo("Resuming backfill %d of %s on %s after key %s (%d rows done).", p.position+1, p.version, p.spec.Table, p.lastKey, p.done)
+		}
+		if err := runBackfill(ctx, db, p.version, p.position, p.spec, p.lastKey, p.resuming, p.done, batchSize); err != nil {
+			return fmt.Errorf("backfill %d of %s on %s: %v (it resumes on the next run)", p.position+1, p.version, p.spec.Table, err)
+		}
+	}
+	return nil
+}
+
+// Updates the table batchSize keys at a time in primary key order, each batch
+// in its own short transaction that also records the last key done
+func runBackfill(ctx context.Context, db *sql.DB, version string, position int, spec backfillSpec, lastKey string, resuming bool, done int64, batchSize int) error {
+	var keyColumn, keyType string
+	err := db.QueryRowContext(ctx, `
+		SELECT a.attname, format_type(a.atttypid, a.atttypmod)
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+		WHERE i.indrelid = to_regclass($1) AND i.indisprimary AND i.indnkeyatts = 1
+	`, spec.Table).Scan(&keyColumn, &keyType)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s needs a single-column primary key to be backfilled in batches", spec.Table)
+	}
+	if err != nil {
+		return err
+	}
+	key := pgx.Identifier{keyColumn}.Sanitize()
+
+	total, _, _, _ := relationStats(ctx, db, spec.Table)
+	where := ""
+	if spec.Where != "" {
+		where = " AND (" + spec.Where + ")"
+	}
+	// Keys after the last one done, with the key as parameter n
+	after := func(n int) string {
+		if !resuming {
+			return "TRUE"
+		}
+		return fmt.Sprintf("%s > CAST($%d AS %s)", key, n, keyType)
+	}
+	keyArgs := func() []any {
+		if !resuming {
+			return nil
+		}
+		return []any{lastKey}
+	}
+
+	started := time.Now()
+	lastReport := started
+	for {
+		// The upper key of the next batch, then the update of that key range
+		var upper sql.NullString
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`
+			SELECT MAX(k)::text FROM (SELECT %[1]s AS k FROM %[2]s WHERE %[3]s ORDER BY %[1]s LIMIT $1) batch
+		`, key, spec.Table, after(2)), append([]any{batchSize}, keyArgs()...)...).Scan(&upper)
+		if err != nil {
+			return err
+		}
+		if !upper.Valid {
+			break
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		updateArgs := append(keyArgs(), upper.String)
+		res, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s SET %s WHERE %s AND %s <= CAST($%d AS %s)%s
+		`, spec.Table, spec.Set, after(1), key, len(updateArgs), keyType, where), updateArgs...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		n, _ := res.RowsAffected()
+		done += n
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s.%s SET last_key = $3, rows_done = $4, updated_at = NOW() WHERE version = $1 AND position = $2
+		`, schemaName, backfillTableName), version, position, upper.String, done)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		lastKey, resuming = upper.String, true
+
+		if time.Since(lastReport) >= 10*time.Second {
+			lastReport = time.Now()
+			if total > 0 {
+				logInfo("Backfill of %s: %d rows updated, key %s (about %d%% of %s rows), %s elapsed",
+					spec.Table, done, lastKey, min(100, done*100/total), formatCount(total), time.Since(started).Round(time.Second))
+			} else {
+				logInfo("Backfill of %s: %d rows updated, key %s, %s elapsed", spec.Table, done, lastKey, time.Since(started).Round(time.Second))
+			}
+		}
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s.%s SET finished_at = NOW(), updated_at = NOW() WHERE version = $1 AND position = $2
+	`, schemaName, backfillTableName), version, position)
+	if err != nil {
+		return err
+	}
+	logInfo("Backfill of %s finished: %d rows updated in %s.", spec.Table, done, time.Since(started).Round(time.Second))
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Handles `baseline --to VERSION`, for adopting the tool on a database whose
+// schema already exists: records every local migration up to VERSION as
+// applied, with its hash and statements, without running it, so that only
+// later migrations run. Versions already in the history are left alone.
+func runBaseline(opts options, dbURL string) error {
+	if opts.to == "" {
+		return fmt.Errorf("baseline requires --to VERSION")
+	}
+	key, err := loadHistoryKey()
+	if err != nil {
+		return err
+	}
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, m := range migrations {
+		found = found || m.Version == opts.to
+	}
+	if !found {
+		return fmt.Errorf("--to %s: no local migration with that version", opts.to)
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := migrate.EnsureTable(ctx, db); err != nil {
+		return err
+	}
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	lock, err := acquireMigrationLock(ctx, db, opts)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer lock.release(ctx)
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	var baseline []Migration
+	for _, m := range migrations {
+		if m.Version > opts.to {
+			continue
+		}
+		if _, ok := applied[m.Version]; ok || m.Directives.RunAlways {
+			continue
+		}
+		baseline = append(baseline, m)
+	}
+	if len(baseline) == 0 {
+		logInfo("Every migration up to %s is already recorded; nothing to baseline.", opts.to)
+		return nil
+	}
+
+	for _, m := range baseline {
+		fmt.Printf("  %s (%s)\n", m.Version, m.Name)
+	}
+	if !opts.yes && !confirm(fmt.Sprintf("Record these %d migration(s) as applied without running them?", len(baseline))) {
+		return fmt.Errorf("baseline cancelled; nothing was changed")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range baseline {
+		if err := recordWithoutRunning(ctx, tx, opts, key, m, "supabase-direct-migrate baseline"); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logInfo("Recorded %d migration(s) up to %s as applied; later migrations run on the next apply.", len(baseline), opts.to)
+	return nil
+}
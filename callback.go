@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Summary of a run, shared by the integrations that report on it
+type runReport struct {
+	RunID      string     `json:"run_id"`
+	Host       string     `json:"host"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Applied    []string   `json:"applied"`
+	RunAlways  []string   `json:"run_always,omitempty"`
+	Skipped    []string   `json:"skipped,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func (r *runReport) finish(err error) {
+	now := time.Now().UTC()
+	r.FinishedAt = &now
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func newRunReport() *runReport {
+	host, _ := os.Hostname()
+	return &runReport{
+		RunID:     newLockHolder(),
+		Host:      host,
+		StartedAt: time.Now().UTC(),
+		Applied:   []string{},
+	}
+}
+
+type callbackPayload struct {
+	Event string `json:"event"`
+	*runReport
+}
+
+// Posts a run event (start, success, failure) to the deployment orchestrator.
+// The body is signed with HMAC-SHA256 and the signature sent as
+// X-Signature-256: sha256=<hex>, the same scheme GitHub webhooks use.
+func sendCallback(url, secret, event string, report *runReport) error {
+	body, err := json.Marshal(callbackPayload{Event: event, runReport: report})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var lastErr error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Migrate-Event", event)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return lastErr
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Reports whether a statement was cancelled (pg_cancel_backend, or a
+// statement timeout)
+func isQueryCanceled(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57014"
+}
+
+// Handles `cancel`: asks the running apply to stop, from another connection.
+// The request is recorded on the lock row, so the runner stops before its
+// next migration, and the statement it is running is cancelled with
+// pg_cancel_backend on its backend and on any parallel sessions of the same
+// migration. The migration's transaction rolls back and the run fails.
+func runCancel(dbURL string) error {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	var holder, version string
+	var pid int
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`
+		UPDATE %s.%s SET cancel_requested_at = NOW()
+		WHERE id = 1 AND expires_at > NOW()
+		RETURNING holder, COALESCE(current_version, ''), COALESCE(backend_pid, 0)
+	`, schemaName, lockTableName)).Scan(&holder, &version, &pid)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no migration run is in progress")
+	}
+	if err != nil {
+		return err
+	}
+	logInfo("Asked %s to stop.", holder)
+	if version == "" || pid == 0 {
+		logInfo("It isn't running a statement right now; it stops before its next migration.")
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT pid, pg_cancel_backend(pid)
+		FROM pg_stat_activity
+		WHERE pid <> pg_backend_pid()
+			AND (pid = $1 OR application_name LIKE 'supabase-direct-migrate/% ' || $2)
+	`, pid, version)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cancelled := 0
+	for rows.Next() {
+		var backend int
+		var ok bool
+		if err := rows.Scan(&backend, &ok); err != nil {
+			return err
+		}
+		if !ok {
+			logWarn("Warning: could not cancel backend %d (it needs the same role or pg_signal_backend)", backend)
+			continue
+		}
+		cancelled++
+		logInfo("Cancelled the statement of backend %d (migration %s).", backend, version)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if cancelled == 0 {
+		return fmt.Errorf("no statement of migration %s could be cancelled; the run still stops before its next migration", version)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lints local migrations against what a target environment already has,
+// read-only: pending migrations must also be newer than the latest one applied
+// there. Meant for PR pipelines, to flag reorder hazards before merge.
+// Returns false if any check failed.
+func runCheck(opts options) bool {
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+
+	applied, source, err := referenceVersions(opts)
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok && !m.Directives.RunAlways {
+			pending = append(pending, m)
+		}
+	}
+
+	problems := checkVersionPolicy(pending, latestVersion(applied), time.Now())
+	for _, p := range problems {
+		logError("%s", p)
+	}
+	if len(problems) > 0 {
+		logError("%d problem(s) found in %d migrations not in %s.", len(problems), len(pending), source)
+		return false
+	}
+
+	logInfo("%d migrations not in %s passed check.", len(pending), source)
+	return true
+}
+
+// Returns the versions a target environment already has, from
+// --against-versions, from the migrations present on --against-branch, or
+// else from the database, along with a description of where they came from
+func referenceVersions(opts options) (map[string]string, string, error) {
+	switch {
+	case opts.againstVersions != "":
+		applied := map[string]string{}
+		for _, v := range strings.Split(opts.againstVersions, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				applied[v] = ""
+			}
+		}
+		return applied, "the given versions", nil
+	case opts.againstBranch != "":
+		applied, err := gitMigrationVersions(opts.againstBranch)
+		return applied, opts.againstBranch, err
+	default:
+		applied, err := fetchAppliedVersions(requireDatabaseURL())
+		return applied, "the database", err
+	}
+}
+
+func fetchAppliedVersions(dbURL string) (map[string]string, error) {
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return fetchAppliedMigrations(context.Background(), db)
+}
+
+// Lists the migration versions present in the migrations directory at a git
+// ref, e.g. the branch a pull request targets
+func gitMigrationVersions(ref string) (map[string]string, error) {
+	out, err := exec.Command("git", "ls-tree", "--name-only", ref, "--", migrationsDir+"/").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git ls-tree %s: %s", ref, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+
+	versions := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		name := filepath.Base(strings.TrimSpace(line))
+		if !isMigrationFile(name) {
+			continue
+		}
+		if version, _, ok := strings.Cut(name, "_"); ok {
+			versions[version] = ""
+		}
+	}
+	return versions, nil
+}
+
+// Returns the highest applied version, or "" when nothing is applied
+func latestVersion(applied map[string]string) string {
+	versions := make([]string, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return ""
+	}
+	sort.Strings(versions)
+	return versions[len(versions)-1]
+}
@@ -0,0 +1,88 @@
+package main
+
+import "github.com/DaviSMoura/supabase-direct-migrate/migrate"
+
+// One SQL command as tokens, for classifying statements by keyword rather
+// than by text, so comments, quoting and odd whitespace can't change the
+// result. A migration statement may hold several commands.
+type sqlCommand []string
+
+// Splits SQL into commands at top-level semicolons. The semicolons inside a
+// BEGIN ATOMIC ... END function body belong to the function. Built with
+// -tags pg_query, PostgreSQL's parser finds the boundaries instead, unless
+// the SQL doesn't parse.
+func splitCommands(sql string) []sqlCommand {
+	var commands []sqlCommand
+	if texts, ok := parserSplitCommands(sql); ok {
+		for _, text := range texts {
+			if tokens := migrate.Tokenize(text); len(tokens) > 0 {
+				commands = append(commands, tokens)
+			}
+		}
+		return commands
+	}
+
+	var current sqlCommand
+	atomic := 0
+	for _, tok := range migrate.Tokenize(sql) {
+		switch {
+		case tok == ";" && atomic == 0:
+			if len(current) > 0 {
+				commands = append(commands, current)
+			}
+			current = nil
+			continue
+		case isKeyword(tok, "ATOMIC") && len(current) > 0 && isKeyword(current[len(current)-1], "BEGIN"):
+			atomic++
+		case isKeyword(tok, "CASE") && atomic > 0:
+			atomic++
+		case isKeyword(tok, "END") && atomic > 0:
+			atomic--
+		}
+		current = append(current, tok)
+	}
+	if len(current) > 0 {
+		commands = append(commands, current)
+	}
+	return commands
+}
+
+// Reports whether the keywords appear in order starting at token i
+func (c sqlCommand) is(i int, words ...string) bool {
+	if i < 0 {
+		return false
+	}
+	for j, w := range words {
+		if i+j >= len(c) || !isKeyword(c[i+j], w) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the index after any of the optional keywords at i
+func (c sqlCommand) skip(i int, words ...string) int {
+	for {
+		moved := false
+		for _, w := range words {
+			if i < len(c) && isKeyword(c[i], w) {
+				i++
+				moved = true
+			}
+		}
+		if !moved {
+			return i
+		}
+	}
+}
+
+// Returns the index of the first occurrence of the keywords at or after i,
+// or -1
+func (c sqlCommand) find(i int, words ...string) int {
+	for ; i < len(c); i++ {
+		if c.is(i, words...) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// Everything in a schema that migrations define, one row of schema and
+// definition per object; dumped by show --scratch and fingerprinted by
+// compare --fingerprint
+const schemaObjectListQuery = `
+	SELECT n.nspname, o.def
+	FROM (
+		SELECT c.relnamespace AS ns, format('column %s.%s %s%s', c.relname, a.attname,
+			format_type(a.atttypid, a.atttypmod), CASE WHEN a.attnotnull THEN ' not null' ELSE '' END) AS def
+		FROM pg_class c
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+		WHERE c.relkind IN ('r', 'p', 'v', 'm', 'f')
+		UNION ALL
+		SELECT c.relnamespace, 'index ' || pg_get_indexdef(i.indexrelid)
+		FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+		UNION ALL
+		SELECT c.relnamespace, format('constraint %s.%s %s', c.relname, k.conname, pg_get_constraintdef(k.oid))
+		FROM pg_constraint k JOIN pg_class c ON c.oid = k.conrelid
+		UNION ALL
+		SELECT p.pronamespace, format('function %s(%s) %s', p.proname, pg_get_function_identity_arguments(p.oid), md5(p.prosrc))
+		FROM pg_proc p
+		UNION ALL
+		SELECT c.relnamespace, format('policy %s.%s %s %s %s', c.relname, pol.polname, pol.polcmd,
+			COALESCE(pg_get_expr(pol.polqual, pol.polrelid), ''), COALESCE(pg_get_expr(pol.polwithcheck, pol.polrelid), ''))
+		FROM pg_policy pol JOIN pg_class c ON c.oid = pol.polrelid
+	) o
+	JOIN pg_namespace n ON n.oid = o.ns
+	WHERE n.nspname NOT IN ('pg_catalog', 'information_schema', 'pg_toast')
+		AND n.nspname NOT LIKE 'pg_temp_%' AND n.nspname NOT LIKE 'pg_toast_temp_%'
+`
+
+// Per-schema fingerprint of schemaObjectListQuery, for compare --fingerprint
+const schemaObjectsQuery = `
+	SELECT nspname, md5(string_agg(def, E'\n' ORDER BY def))
+	FROM (` + schemaObjectListQuery + `) objects
+	GROUP BY nspname
+`
+
+// Returns schema -> fingerprint of its tables, columns, indexes, constraints,
+// functions and policies
+func schemaFingerprints(ctx context.Context, dbURL string) (map[string]string, error) {
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, schemaObjectsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fingerprints := map[string]string{}
+	for rows.Next() {
+		var schema, fingerprint string
+		if err := rows.Scan(&schema, &fingerprint); err != nil {
+			return nil, err
+		}
+		fingerprints[schema] = fingerprint
+	}
+	return fingerprints, rows.Err()
+}
+
+// Returns the sorted keys of a that are missing from b
+func missingKeys(a, b map[string]string) []string {
+	var missing []string
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Returns the sorted keys present in both with different values
+func differingKeys(a, b map[string]string) []string {
+	var differing []string
+	for k, v := range a {
+		if w, ok := b[k]; ok && v != w {
+			differing = append(differing, k)
+		}
+	}
+	sort.Strings(differing)
+	return differing
+}
+
+// Diffs the applied migrations of two databases, and with --fingerprint their
+// schemas. Reports false when they differ.
+func runCompare(opts options) (bool, error) {
+	if opts.compareA == "" || opts.compareB == "" {
+		return false, fmt.Errorf("compare needs --a URL and --b URL")
+	}
+	warnWithoutTLS(opts.compareA)
+	warnWithoutTLS(opts.compareB)
+	ctx := context.Background()
+	nameA, nameB := "a ("+stateTarget(opts.compareA)+")", "b ("+stateTarget(opts.compareB)+")"
+
+	historyA, err := fetchAppliedAt(ctx, opts.compareA)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %v", nameA, err)
+	}
+	historyB, err := fetchAppliedAt(ctx, opts.compareB)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %v", nameB, err)
+	}
+
+	same := true
+	report := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		same = false
+		fmt.Printf("%s:\n", title)
+		for _, item := range items {
+			fmt.Printf("  %s\n", item)
+		}
+	}
+	report("Missing from "+nameB, missingKeys(historyA, historyB))
+	report("Missing from "+nameA, missingKeys(historyB, historyA))
+	report("Applied with different hashes", differingKeys(historyA, historyB))
+
+	if opts.fingerprint {
+		schemasA, err := schemaFingerprints(ctx, opts.compareA)
+		if err != nil {
+			return false, fmt.Errorf("fingerprinting %s: %v", nameA, err)
+		}
+		schemasB, err := schemaFingerprints(ctx, opts.compareB)
+		if err != nil {
+			return false, fmt.Errorf("fingerprinting %s: %v", nameB, err)
+		}
+		report("Schemas only in "+nameA, missingKeys(schemasA, schemasB))
+		report("Schemas only in "+nameB, missingKeys(schemasB, schemasA))
+		report("Schemas that differ", differingKeys(schemasA, schemasB))
+	}
+
+	if same {
+		what := "migration histories"
+		if opts.fingerprint {
+			what += " and schemas"
+		}
+		logInfo("%s and %s have the same %s (%d migrations).", nameA, nameB, what, len(historyA))
+	}
+	return same, nil
+}
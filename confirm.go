@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Asks a question on the terminal and returns the answer, "" when there is
+// no input at all
+func prompt(question string) string {
+	fmt.Fprintf(os.Stderr, "%s ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+// Asks a yes/no question on the terminal; anything but y or yes, including
+// no input at all, is a no
+func confirm(question string) bool {
+	answer := strings.ToLower(prompt(question + " [y/N]"))
+	return answer == "y" || answer == "yes"
+}
+
+// Reports whether applying to the database needs confirming: with --confirm,
+// or when its host matches one of the comma-separated --production-hosts
+// patterns (path.Match globs, e.g. db.abcdefgh.supabase.co or *.prod.internal)
+func needsConfirmation(opts options, host string) bool {
+	if opts.confirm {
+		return true
+	}
+	for _, pattern := range strings.Split(opts.productionHosts, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists what apply is about to run and has the operator type the database
+// name to go on. --yes skips it for CI.
+func confirmApply(opts options, dbURL string, pending, always []Migration) error {
+	config, err := pgconn.ParseConfig(dbURL)
+	if err != nil {
+		return err
+	}
+	if opts.yes || len(pending) == 0 || !needsConfirmation(opts, config.Host) {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "About to apply to %s on %s:\n", config.Database, config.Host)
+	for _, m := range pending {
+		fmt.Fprintf(os.Stderr, "  %s (%s), %d statement(s)\n", m.Version, m.Name, len(m.Statements))
+	}
+	for _, m := range always {
+		fmt.Fprintf(os.Stderr, "  %s (%s), %d statement(s), run-always\n", m.Version, m.Name, len(m.Statements))
+	}
+	if prompt(fmt.Sprintf("Type the database name (%s) to continue:", config.Database)) != config.Database {
+		return fmt.Errorf("apply to %s was not confirmed; nothing was applied (pass --yes to skip the confirmation)", config.Host)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultCronFile = "./supabase/cron.yaml"
+
+// A pg_cron job as declared in cron.yaml
+type cronJob struct {
+	Name     string `yaml:"name"`
+	Schedule string `yaml:"schedule"`
+	Command  string `yaml:"command"`
+}
+
+type cronFile struct {
+	Jobs []cronJob `yaml:"jobs"`
+}
+
+// Loads the declared jobs. A missing file means cron jobs aren't managed.
+func loadCronJobs(path string) ([]cronJob, bool, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var f cronFile
+	if err := yaml.Unmarshal(raw, &f); err != nil {
+		return nil, false, fmt.Errorf("%s: %v", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i, job := range f.Jobs {
+		if job.Name == "" || job.Schedule == "" || job.Command == "" {
+			return nil, false, fmt.Errorf("%s: job %d needs name, schedule and command", path, i+1)
+		}
+		if seen[job.Name] {
+			return nil, false, fmt.Errorf("%s: duplicate job name %s", path, job.Name)
+		}
+		seen[job.Name] = true
+	}
+	return f.Jobs, true, nil
+}
+
+// A job in cron.job; jobs scheduled without a name only have their jobid
+type scheduledJob struct {
+	Name  string
+	JobID int64
+}
+
+func (j scheduledJob) String() string {
+	if j.Name == "" {
+		return fmt.Sprintf("%d (unnamed)", j.JobID)
+	}
+	return j.Name
+}
+
+// Differences between cron.yaml and cron.job
+type cronDrift struct {
+	Missing   []cronJob      // declared but not scheduled
+	Changed   []cronJob      // scheduled with another schedule or command
+	Unmanaged []scheduledJob // scheduled but not declared
+}
+
+func (d cronDrift) empty() bool {
+	return len(d.Missing) == 0 && len(d.Changed) == 0 && len(d.Unmanaged) == 0
+}
+
+func diffCronJobs(ctx context.Context, db *sql.DB, jobs []cronJob) (cronDrift, error) {
+	var drift cronDrift
+
+	var installed bool
+	if err := db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pg_cron')`).Scan(&installed); err != nil {
+		return drift, err
+	}
+	if !installed {
+		return drift, fmt.Errorf("cron jobs are declared but the pg_cron extension is not installed")
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT jobid, COALESCE(jobname, ''), schedule, command FROM cron.job ORDER BY jobid`)
+	if err != nil {
+		return drift, err
+	}
+	defer rows.Close()
+
+	// Unnamed jobs can't be declared, so they are always unmanaged
+	existing := map[string]cronJob{}
+	ids := map[string]int64{}
+	for rows.Next() {
+		var id int64
+		var job cronJob
+		if err := rows.Scan(&id, &job.Name, &job.Schedule, &job.Command); err != nil {
+			return drift, err
+		}
+		if job.Name == "" {
+			drift.Unmanaged = append(drift.Unmanaged, scheduledJob{JobID: id})
+			continue
+		}
+		existing[job.Name] = job
+		ids[job.Name] = id
+	}
+	if err := rows.Err(); err != nil {
+		return drift, err
+	}
+
+	declared := map[string]bool{}
+	for _, job := range jobs {
+		declared[job.Name] = true
+		current, ok := existing[job.Name]
+		switch {
+		case !ok:
+			drift.Missing = append(drift.Missing, job)
+		case current.Schedule != job.Schedule || current.Command != job.Command:
+			drift.Changed = append(drift.Changed, job)
+		}
+	}
+	for name := range existing {
+		if !declared[name] {
+			drift.Unmanaged = append(drift.Unmanaged, scheduledJob{Name: name, JobID: ids[name]})
+		}
+	}
+	sort.Slice(drift.Unmanaged, func(i, j int) bool {
+		a, b := drift.Unmanaged[i], drift.Unmanaged[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.JobID < b.JobID
+	})
+	return drift, nil
+}
+
+// Schedules missing and changed jobs (cron.schedule upserts by name) and, when
+// prune is set, unschedules jobs that aren't declared. Without prune those are
+// only reported.
+func reconcileCronJobs(ctx context.Context, db *sql.DB, jobs []cronJob, prune bool) error {
+	drift, err := diffCronJobs(ctx, db, jobs)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range append(drift.Missing, drift.Changed...) {
+		if _, err := db.ExecContext(ctx, `SELECT cron.schedule($1, $2, $3)`, job.Name, job.Schedule, job.Command); err != nil {
+			return fmt.Errorf("scheduling cron job %s: %v", job.Name, err)
+		}
+		logInfo("Scheduled cron job %s (%s).", job.Name, job.Schedule)
+	}
+
+	for _, job := range drift.Unmanaged {
+		if !prune {
+			logWarn("Warning: cron job %s is not declared in cron.yaml", job)
+			continue
+		}
+		// A text argument would pick the by-name overload, so unnamed jobs
+		// are unscheduled by jobid
+		var err error
+		if job.Name == "" {
+			_, err = db.ExecContext(ctx, `SELECT cron.unschedule($1::bigint)`, job.JobID)
+		} else {
+			_, err = db.ExecContext(ctx, `SELECT cron.unschedule($1)`, job.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("unscheduling cron job %s: %v", job, err)
+		}
+		logInfo("Unscheduled cron job %s.", job)
+	}
+
+	if drift.empty() {
+		logInfo("Cron jobs are up to date.")
+	}
+	return nil
+}
+
+// Handles `cron check` (report drift, fail if any) and `cron sync`
+func runCron(opts options, dbURL string, args []string) (bool, error) {
+	if len(args) != 1 || (args[0] != "check" && args[0] != "sync") {
+		return false, fmt.Errorf("cron requires check or sync")
+	}
+
+	jobs, ok, err := loadCronJobs(opts.cronFile)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, fmt.Errorf("%s not found", opts.cronFile)
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	if args[0] == "sync" {
+		return true, reconcileCronJobs(ctx, db, jobs, opts.cronPrune)
+	}
+
+	drift, err := diffCronJobs(ctx, db, jobs)
+	if err != nil {
+		return false, err
+	}
+	for _, job := range drift.Missing {
+		logError("cron job %s is declared but not scheduled", job.Name)
+	}
+	for _, job := range drift.Changed {
+		logError("cron job %s differs from cron.yaml", job.Name)
+	}
+	for _, job := range drift.Unmanaged {
+		logError("cron job %s is scheduled but not declared in cron.yaml", job)
+	}
+	if !drift.empty() {
+		return false, nil
+	}
+	logInfo("%d cron jobs match %s.", len(jobs), opts.cronFile)
+	return true, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sends run metrics and a Datadog event to the local DogStatsD agent. Only
+// called when DD_AGENT_HOST is set; DD_DOGSTATSD_PORT, DD_ENV and DD_TAGS
+// follow the usual Datadog agent conventions.
+func emitDatadog(dbURL string, report *runReport) error {
+	host := os.Getenv("DD_AGENT_HOST")
+	port := os.Getenv("DD_DOGSTATSD_PORT")
+	if port == "" {
+		port = "8125"
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	tags := datadogTags(dbURL)
+	status := "success"
+	alertType := "success"
+	if report.Error != "" {
+		status = "failure"
+		alertType = "error"
+	}
+	runTags := append(append([]string{}, tags...), "status:"+status)
+
+	var duration time.Duration
+	if report.FinishedAt != nil {
+		duration = report.FinishedAt.Sub(report.StartedAt)
+	}
+
+	lines := []string{
+		statsdLine("supabase_migrate.run", "1", "c", runTags),
+		statsdLine("supabase_migrate.run.duration", fmt.Sprintf("%d", duration.Milliseconds()), "ms", runTags),
+		statsdLine("supabase_migrate.migrations.applied", fmt.Sprintf("%d", len(report.Applied)), "c", runTags),
+	}
+
+	// One event per run, tagged with every version applied
+	eventTags := append([]string{}, runTags...)
+	for _, v := range report.Applied {
+		eventTags = append(eventTags, "migration_version:"+v)
+	}
+	title := fmt.Sprintf("Supabase migrations %s", status)
+	text := fmt.Sprintf("Applied %d migration(s) in %s on %s.", len(report.Applied), duration.Round(time.Millisecond), report.Host)
+	if len(report.Applied) > 0 {
+		text += " Versions: " + strings.Join(report.Applied, ", ")
+	}
+	if report.Error != "" {
+		text += " Error: " + report.Error
+	}
+	text = strings.ReplaceAll(text, "\n", "\\n")
+	event := fmt.Sprintf("_e{%d,%d}:%s|%s|t:%s|s:supabase-direct-migrate", len(title), len(text), title, text, alertType)
+	if len(eventTags) > 0 {
+		event += "|#" + strings.Join(eventTags, ",")
+	}
+	lines = append(lines, event)
+
+	for _, line := range lines {
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statsdLine(metric, value, kind string, tags []string) string {
+	line := fmt.Sprintf("%s:%s|%s", metric, value, kind)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// Builds the common tags: project, env and anything listed in DD_TAGS
+func datadogTags(dbURL string) []string {
+	var tags []string
+	if project := projectRef(dbURL); project != "" {
+		tags = append(tags, "project:"+project)
+	}
+	if env := os.Getenv("DD_ENV"); env != "" {
+		tags = append(tags, "env:"+env)
+	}
+	for _, t := range strings.FieldsFunc(os.Getenv("DD_TAGS"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// Derives the Supabase project ref from a connection string, either from a
+// direct host (db.<ref>.supabase.co) or a pooler user (postgres.<ref>).
+// Falls back to the database name for plain PostgreSQL URLs.
+func projectRef(dbURL string) string {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return ""
+	}
+	host := u.Hostname()
+	if strings.HasPrefix(host, "db.") && strings.HasSuffix(host, ".supabase.co") {
+		return strings.TrimSuffix(strings.TrimPrefix(host, "db."), ".supabase.co")
+	}
+	if user := u.User.Username(); strings.HasPrefix(user, "postgres.") {
+		return strings.TrimPrefix(user, "postgres.")
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
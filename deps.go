@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// A view or function a statement drops without CASCADE
+type droppedObject struct {
+	Kind string // "view" or "function"
+	Name string // as written; functions include their argument types if given
+	Args bool   // the function's argument types were given
+}
+
+// Returns the views, materialized views, functions and procedures a statement
+// drops without CASCADE
+func droppedObjects(stmt string) []droppedObject {
+	var dropped []droppedObject
+	for _, cmd := range splitCommands(stmt) {
+		if !cmd.is(0, "DROP") || cmd.find(0, "CASCADE") >= 0 {
+			continue
+		}
+		var kind string
+		i := 1
+		switch {
+		case cmd.is(1, "VIEW"):
+			kind, i = "view", 2
+		case cmd.is(1, "MATERIALIZED", "VIEW"):
+			kind, i = "view", 3
+		case cmd.is(1, "FUNCTION"), cmd.is(1, "PROCEDURE"), cmd.is(1, "ROUTINE"):
+			kind, i = "function", 2
+		default:
+			continue
+		}
+		i = cmd.skip(i, "IF", "EXISTS")
+		for i < len(cmd) {
+			var name string
+			name, i = readQualifiedName(cmd, i)
+			obj := droppedObject{Kind: kind, Name: name}
+			if kind == "function" && i < len(cmd) && cmd[i] == "(" {
+				end := i + 1
+				for depth := 1; end < len(cmd) && depth > 0; end++ {
+					switch cmd[end] {
+					case "(":
+						depth++
+					case ")":
+						depth--
+					}
+				}
+				obj.Name += joinTokens(cmd[i:end])
+				obj.Args = true
+				i = end
+			}
+			dropped = append(dropped, obj)
+			if i >= len(cmd) || cmd[i] != "," {
+				break
+			}
+			i++
+		}
+	}
+	return dropped
+}
+
+// Joins tokens back into SQL, with a space only between two words
+func joinTokens(tokens []string) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && migrate.IsWordByte(tok[0]) && migrate.IsWordByte(tokens[i-1][len(tokens[i-1])-1]) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
+
+// A view depending, directly or through other views, on a dropped object
+type dependentView struct {
+	Name         string
+	Materialized bool
+	Depth        int    // 1 for direct dependents
+	Definition   string // CREATE statement re-creating it
+	Grants       []string
+}
+
+// Views depending on an object, shallowest first, with what it takes to
+// re-create them
+const dependentViewsQuery = `
+	WITH RECURSIVE deps(oid, depth) AS (
+		SELECT r.ev_class, 1
+		FROM pg_depend d JOIN pg_rewrite r ON r.oid = d.objid
+		WHERE d.classid = 'pg_rewrite'::regclass AND d.refclassid = $2::regclass
+			AND d.refobjid = $1 AND r.ev_class <> $1
+		UNION
+		SELECT r.ev_class, deps.depth + 1
+		FROM deps
+		JOIN pg_depend d ON d.refclassid = 'pg_class'::regclass AND d.refobjid = deps.oid
+		JOIN pg_rewrite r ON r.oid = d.objid AND d.classid = 'pg_rewrite'::regclass
+		WHERE r.ev_class <> deps.oid AND deps.depth < 50
+	)
+	SELECT c.oid::regclass::text, c.relkind = 'm', max(deps.depth),
+		format('CREATE %sVIEW %s%s AS %s',
+			CASE c.relkind WHEN 'm' THEN 'MATERIALIZED ' ELSE '' END, c.oid::regclass,
+			CASE WHEN c.reloptions IS NULL THEN '' ELSE format(' WITH (%s)', array_to_string(c.reloptions, ', ')) END,
+			rtrim(pg_get_viewdef(c.oid), ';')),
+		COALESCE((
+			SELECT string_agg(g, E'\n') FROM (
+				SELECT format('GRANT %s ON TABLE %s TO %s', string_agg(a.privilege_type, ', ' ORDER BY a.privilege_type), c.oid::regclass, ` + granteeName + `) AS g
+				FROM aclexplode(c.relacl) a
+				WHERE a.grantee <> c.relowner
+				GROUP BY a.grantee
+			) grants
+		), '')
+	FROM deps JOIN pg_class c ON c.oid = deps.oid
+	GROUP BY c.oid
+	ORDER BY max(deps.depth), c.oid::regclass::text
+`
+
+// Objects other than views that depend on an object: triggers, column
+// defaults, policies and the like, which can't be re-created from here
+const otherDependentsQuery = `
+	SELECT pg_describe_object(d.classid, d.objid, d.objsubid)
+	FROM pg_depend d
+	WHERE d.refclassid = $2::regclass AND d.refobjid = $1 AND d.deptype = 'n'
+		AND d.classid <> 'pg_rewrite'::regclass
+	ORDER BY 1
+`
+
+// Checks that the pending migrations don't drop views or functions other
+// objects depend on, which PostgreSQL would only refuse halfway through the
+// deploy. With recreate, dependent views are re-created instead; returns
+// version -> statements for the migrations rewritten to do so.
+func planDependents(ctx context.Context, db *sql.DB, migrations []Migration, recreate bool) (map[string][]string, error) {
+	rewritten := map[string][]string{}
+	var problems []string
+	for _, m := range migrations {
+		statements, blocked, err := resolveDependents(ctx, db, m, recreate)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, blocked...)
+		if len(statements) != len(m.Statements) {
+			rewritten[m.Version] = statements
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("pending migrations drop objects others depend on:\n  - %s\nDrop the dependents first in the migration, or pass --recreate-dependents to re-create dependent views",
+			strings.Join(problems, "\n  - "))
+	}
+	return rewritten, nil
+}
+
+// Finds the objects depending on what a migration drops without CASCADE,
+// leaving out the ones the migration drops itself, and returns them as
+// problems. With recreate, dependent views aren't problems: the returned
+// statements drop them before each DROP and re-create them, with their
+// grants, after the migration's last statement. Anything else depending on a
+// dropped object, and dependents in a -- no-transaction migration, are still
+// problems.
+func resolveDependents(ctx context.Context, db *sql.DB, m Migration, recreate bool) ([]string, []string, error) {
+	var problems []string
+	var statements, recreated []string
+	droppedOIDs := map[uint32]bool{}
+	viewsDropped := map[string]bool{}
+
+	for i, stmt := range m.Statements {
+		for _, obj := range droppedObjects(stmt) {
+			resolve, catalog := `SELECT to_regclass($1)::oid`, "pg_class"
+			if obj.Kind == "function" {
+				resolve, catalog = `SELECT to_regproc($1)::oid`, "pg_proc"
+				if obj.Args {
+					resolve = `SELECT to_regprocedure($1)::oid`
+				}
+			}
+			var oid sql.NullInt64
+			if err := db.QueryRowContext(ctx, resolve, obj.Name).Scan(&oid); err != nil || !oid.Valid {
+				// Not there (yet), or ambiguous; the statement reports it itself
+				continue
+			}
+			droppedOIDs[uint32(oid.Int64)] = true
+
+			views, err := dependentViews(ctx, db, oid.Int64, catalog)
+			if err != nil {
+				return nil, nil, err
+			}
+			var blocking []string
+			for _, v := range views {
+				if viewsDropped[v.Name] {
+					continue
+				}
+				var vOID int64
+				if err := db.QueryRowContext(ctx, `SELECT $1::regclass::oid`, v.Name).Scan(&vOID); err == nil && droppedOIDs[uint32(vOID)] {
+					continue
+				}
+				blocking = append(blocking, v.Name)
+			}
+			others, err := queryStrings(ctx, db, otherDependentsQuery, oid.Int64, catalog)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(blocking) == 0 && len(others) == 0 {
+				continue
+			}
+			if !recreate || len(others) > 0 || m.Directives.NoTransaction {
+				problems = append(problems, fmt.Sprintf("%s (%s), statement %d: DROP %s %s would fail; depending on it: %s",
+					m.Version, m.Name, i+1, strings.ToUpper(obj.Kind), obj.Name, strings.Join(append(blocking, others...), ", ")))
+				continue
+			}
+
+			// Deepest first, so each view goes before the ones it reads from
+			for k := len(views) - 1; k >= 0; k-- {
+				v := views[k]
+				if viewsDropped[v.Name] || !slices.Contains(blocking, v.Name) {
+					continue
+				}
+				kind := "VIEW"
+				if v.Materialized {
+					kind = "MATERIALIZED VIEW"
+				}
+				statements = append(statements, fmt.Sprintf("DROP %s %s", kind, v.Name))
+				viewsDropped[v.Name] = true
+			}
+			for _, v := range views {
+				if slices.Contains(blocking, v.Name) {
+					recreated = append(recreated, v.Definition)
+					recreated = append(recreated, v.Grants...)
+				}
+			}
+			logInfo("%s (%s), statement %d: re-creating %s around DROP %s %s.", m.Version, m.Name, i+1, strings.Join(blocking, ", "), strings.ToUpper(obj.Kind), obj.Name)
+		}
+		statements = append(statements, stmt)
+	}
+
+	return append(statements, recreated...), problems, nil
+}
+
+func dependentViews(ctx context.Context, db *sql.DB, oid int64, catalog string) ([]dependentView, error) {
+	rows, err := db.QueryContext(ctx, dependentViewsQuery, oid, catalog)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var views []dependentView
+	for rows.Next() {
+		var v dependentView
+		var grants string
+		if err := rows.Scan(&v.Name, &v.Materialized, &v.Depth, &v.Definition, &grants); err != nil {
+			return nil, err
+		}
+		if grants != "" {
+			v.Grants = strings.Split(grants, "\n")
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
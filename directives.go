@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Per-migration options declared in SQL comments, e.g.
+//
+//	-- no-transaction
+//	-- lock-retry: 5x 10s
+//	-- statement-timeout: 30min
+//	-- requires-extension: pg_cron, postgis
+//	-- realtime: add public.messages
+//	-- run-always
+//	-- run-as: storage_admin
+//	-- parallel ... -- end-parallel
+//	-- backfill: public.orders SET total_cents = total * 100 WHERE total_cents IS NULL
+//	-- skip-if: SELECT to_regclass('public.legacy_orders') IS NULL
+//	-- triggers-hook: redeploy-functions
+//	-- partitions: public.events monthly 3
+//	-- template
+type Directives struct {
+	// Run statements one by one outside a transaction
+	NoTransaction bool
+	// Overrides --lock-retry for this migration
+	LockRetry *lockRetryPolicy
+	// Extensions that must be installed before the migration runs
+	RequiresExtensions []string
+	// Tables to add to or remove from the supabase_realtime publication
+	Realtime []realtimeChange
+	// Run on every apply, after the versioned migrations, without recording
+	// it in the history
+	RunAlways bool
+	// Role to SET ROLE to while the statements run
+	RunAs string
+	// Has -- parallel blocks of statements to run concurrently
+	Parallel bool
+	// Updates to run in key-ordered batches after the migration commits
+	Backfills []backfillSpec
+	// Query returning a boolean; when true the migration is recorded as
+	// skipped instead of run
+	SkipIf string
+	// Hooks from the hooks file to run once the apply succeeds
+	TriggersHooks []string
+	// Range-partitioned tables to create time-based partitions ahead for
+	Partitions []partitionSpec
+	// Rendered with text/template before it is split and hashed
+	Template bool
+	// Overrides statement_timeout on the migration's connections
+	StatementTimeout time.Duration
+}
+
+var directiveLine = regexp.MustCompile(`^--\s*([a-z][a-z-]*)\s*(?::\s*(.*))?$`)
+
+// Parses directive comments anywhere in a migration. Comments that don't name
+// a known directive are ordinary comments.
+func parseDirectives(raw string) (Directives, error) {
+	var d Directives
+	for _, line := range strings.Split(raw, "\n") {
+		match := directiveLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		name, value := match[1], strings.TrimSpace(match[2])
+		switch name {
+		case "no-transaction":
+			d.NoTransaction = true
+		case "parallel":
+			d.Parallel = true
+		case "template":
+			d.Template = true
+		case "run-always":
+			d.RunAlways = true
+		case "run-as":
+			if value == "" || len(migrate.Tokenize(value)) != 1 {
+				return d, fmt.Errorf("invalid -- run-as directive: expected a single role name, got %q", value)
+			}
+			d.RunAs = value
+		case "backfill":
+			spec, err := parseBackfillDirective(value)
+			if err != nil {
+				return d, fmt.Errorf("invalid -- backfill directive: %v", err)
+			}
+			d.Backfills = append(d.Backfills, spec)
+		case "partitions":
+			spec, err := parsePartitionsDirective(value)
+			if err != nil {
+				return d, fmt.Errorf("invalid -- partitions directive: %v", err)
+			}
+			d.Partitions = append(d.Partitions, spec)
+		case "skip-if":
+			if value == "" {
+				return d, fmt.Errorf("invalid -- skip-if directive: expected a query returning a boolean")
+			}
+			d.SkipIf = value
+		case "statement-timeout":
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return d, fmt.Errorf("invalid -- statement-timeout directive: %v", err)
+			}
+			d.StatementTimeout = timeout
+		case "lock-retry":
+			policy, err := parseLockRetry(value)
+			if err != nil {
+				return d, fmt.Errorf("invalid -- lock-retry directive: %v", err)
+			}
+			d.LockRetry = policy
+		case "realtime":
+			changes, err := parseRealtimeDirective(value)
+			if err != nil {
+				return d, fmt.Errorf("invalid -- realtime directive: %v", err)
+			}
+			d.Realtime = append(d.Realtime, changes...)
+		case "triggers-hook":
+			if value == "" {
+				return d, fmt.Errorf("invalid -- triggers-hook directive: expected a hook name")
+			}
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					d.TriggersHooks = append(d.TriggersHooks, name)
+				}
+			}
+		case "requires-extension":
+			for _, ext := range strings.Split(value, ",") {
+				if ext = strings.TrimSpace(ext); ext != "" {
+					d.RequiresExtensions = append(d.RequiresExtensions, ext)
+				}
+			}
+		}
+	}
+	if d.Parallel && !d.NoTransaction {
+		return d, fmt.Errorf("-- parallel blocks need -- no-transaction")
+	}
+	if d.RunAlways && len(d.Backfills) > 0 {
+		return d, fmt.Errorf("-- backfill can't be used in a -- run-always migration")
+	}
+	return d, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+const downSuffix = ".down.sql"
+
+// Reports whether a file in the migrations directory is an up migration;
+// {version}_{name}.down.sql files belong to the migration of the same name
+func isMigrationFile(name string) bool {
+	return strings.HasSuffix(name, ".sql") && !strings.HasSuffix(name, downSuffix)
+}
+
+// Reads {version}_{name}.down.sql for a migration file, if there is one. A
+// migration can't have both a down file and a -- down section.
+func readDownFile(filename string, section []string) ([]string, error) {
+	downName := strings.TrimSuffix(filename, ".sql") + downSuffix
+	raw, err := os.ReadFile(filepath.Join(migrationsDir, downName))
+	if os.IsNotExist(err) {
+		return section, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if section != nil {
+		return nil, fmt.Errorf("%s has both a -- down section and %s", filename, downName)
+	}
+	expanded, err := expandPsqlVariables(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", downName, err)
+	}
+	return migrate.SplitStatements(expanded), nil
+}
+
+// Reverts the latest applied migrations, the last applied first: the last
+// --steps (1 by default), or every one after --to VERSION or the version
+// tagged --to-tag.
+// Each migration's down statements run in one transaction together with the
+// removal of its history row. Nothing runs unless every migration to revert
+// has down statements, except ones skipped by -- skip-if, which only lose
+// their history row.
+func runDown(opts options, dbURL string) error {
+	if (opts.steps > 0 && opts.to != "") || (opts.toTag != "" && (opts.steps > 0 || opts.to != "")) {
+		return fmt.Errorf("down takes one of --steps, --to and --to-tag")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	lock, err := acquireMigrationLock(ctx, db, opts)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer lock.release(ctx)
+
+	if opts.toTag != "" {
+		if opts.to, err = tagVersion(ctx, db, opts.toTag); err != nil {
+			return err
+		}
+	}
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	versions, err := reverseApplyOrder(ctx, db, applied)
+	if err != nil {
+		return err
+	}
+
+	var targets []string
+	switch {
+	case opts.to != "":
+		if _, ok := applied[opts.to]; !ok {
+			return fmt.Errorf("--to %s is not an applied version", opts.to)
+		}
+		for _, v := range versions {
+			if v > opts.to {
+				targets = append(targets, v)
+			}
+		}
+	default:
+		steps := max(opts.steps, 1)
+		targets = versions[:min(steps, len(versions))]
+	}
+	if len(targets) == 0 {
+		logInfo("Nothing to revert.")
+		return nil
+	}
+
+	skipped, err := skippedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+	local := map[string]Migration{}
+	for _, m := range migrations {
+		local[m.Version] = m
+	}
+	var missing []string
+	for _, v := range targets {
+		if m, ok := local[v]; !skipped[v] && (!ok || m.Down == nil) {
+			missing = append(missing, v)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no down migration for %s; add a {version}_{name}%s file or a -- down section", strings.Join(missing, ", "), downSuffix)
+	}
+
+	for _, v := range targets {
+		if err := lock.Err(); err != nil {
+			return fmt.Errorf("lost migration lock: %v", err)
+		}
+		m, ok := local[v]
+		if !ok {
+			m = Migration{Version: v}
+		}
+		if skipped[v] {
+			// Its statements never ran, so there is nothing to undo
+			m.Down = nil
+			logInfo("Removing skipped migration: %s (%s)", m.Version, m.Name)
+		} else {
+			logInfo("Reverting migration: %s (%s)", m.Version, m.Name)
+		}
+		if err := revertMigration(ctx, db, m, opts); err != nil {
+			return fmt.Errorf("reverting %s: %v", m.Version, err)
+		}
+		logInfo("Migration %s reverted.", m.Version)
+	}
+	logInfo("Reverted %d migration(s).", len(targets))
+	return nil
+}
+
+// Returns the applied versions latest applied first, by created_at, so
+// migrations applied out of order are reverted before the newer versions
+// applied ahead of them. Versions covered by an archive baseline come last,
+// newest first.
+func reverseApplyOrder(ctx context.Context, db *sql.DB, applied map[string]string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version FROM %s.%s
+		WHERE created_by IS DISTINCT FROM $1
+		ORDER BY created_at DESC NULLS LAST, version DESC
+	`, schemaName, tableName), baselineCreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []string
+	seen := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+		seen[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var archived []string
+	for v := range applied {
+		if !seen[v] {
+			archived = append(archived, v)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(archived)))
+	return append(versions, archived...), nil
+}
+
+// Returns the versions recorded as skipped by their -- skip-if guard
+func skippedVersions(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version FROM %s.%s t WHERE COALESCE(to_jsonb(t)->>'skipped_reason', '') <> ''
+	`, schemaName, tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	skipped := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		skipped[version] = true
+	}
+	return skipped, rows.Err()
+}
+
+// Runs a migration's down statements and deletes its history row in one
+// transaction, under --statement-timeout and --lock-timeout
+func revertMigration(ctx context.Context, db *sql.DB, m Migration, opts options) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setLocalTimeouts(ctx, tx, opts.statementTimeout, opts.lockTimeout); err != nil {
+		return err
+	}
+
+	for i, stmt := range m.Down {
+		logDebug("Executing down statement %d/%d of %s:\n%s", i+1, len(m.Down), m.Version, stmt)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("statement %d: %v", i+1, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s.%s WHERE version = $1`, schemaName, tableName), m.Version)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("it is recorded in the archive baseline, which can't be reverted")
+	}
+	return tx.Commit()
+}
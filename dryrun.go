@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Reports whether a statement is a single DML command EXPLAIN can plan
+func explainable(stmt string) bool {
+	commands := splitCommands(stmt)
+	if len(commands) != 1 {
+		return false
+	}
+	cmd := commands[0]
+	return cmd.is(0, "INSERT") || cmd.is(0, "UPDATE") || cmd.is(0, "DELETE") ||
+		cmd.is(0, "MERGE") || cmd.is(0, "SELECT") || cmd.is(0, "WITH")
+}
+
+// Prints the statements apply would run, without changing anything: the
+// history isn't created or written and no lock is taken. With --explain, the
+// plan of each DML statement is printed too, from a read-only transaction
+// that is rolled back; statements on tables an earlier pending statement
+// creates can't be planned.
+func runDryRun(opts options, dbURL string) error {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+
+	// In the order apply runs them: versioned migrations, then run-always ones
+	var pending, always []Migration
+	for _, m := range migrations {
+		if m.Directives.RunAlways {
+			always = append(always, m)
+		} else if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if pending, err = limitPending(pending, migrations, opts.to, opts.steps); err != nil {
+		return err
+	}
+	toRun := append(pending, always...)
+	if err := checkTransactionSafety(toRun); err != nil {
+		return err
+	}
+
+	var tx *sql.Tx
+	if opts.explain {
+		if tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+
+	for _, m := range toRun {
+		var notes []string
+		if m.Directives.RunAlways {
+			notes = append(notes, "run-always")
+		}
+		if m.Directives.NoTransaction {
+			notes = append(notes, "no-transaction")
+		}
+		if m.Directives.RunAs != "" {
+			notes = append(notes, "run-as "+m.Directives.RunAs)
+		}
+		if m.Directives.SkipIf != "" {
+			notes = append(notes, "skip-if "+m.Directives.SkipIf)
+		}
+		header := fmt.Sprintf("-- %s (%s)", m.Version, m.Name)
+		if len(notes) > 0 {
+			header += ", " + strings.Join(notes, ", ")
+		}
+		fmt.Println(header)
+
+		for i, stmt := range m.Statements {
+			if i > 0 {
+				fmt.Println("-- statement-breakpoint")
+			}
+			fmt.Println(stmt)
+			if tx != nil && explainable(stmt) {
+				fmt.Println(explainStatement(ctx, tx, stmt))
+			}
+		}
+		fmt.Println()
+	}
+
+	if len(toRun) == 0 {
+		logInfo("Dry run: nothing to apply.")
+	} else {
+		logInfo("Dry run: %d migration(s) would be applied; nothing was changed.", len(toRun))
+	}
+	return nil
+}
+
+// Returns the plan of a statement as SQL comments, or why it couldn't be
+// planned. A failed EXPLAIN is rolled back to a savepoint so the next one can
+// run.
+func explainStatement(ctx context.Context, tx *sql.Tx, stmt string) string {
+	if _, err := tx.ExecContext(ctx, `SAVEPOINT dry_run`); err != nil {
+		return "-- could not explain: " + err.Error()
+	}
+	rows, err := tx.QueryContext(ctx, "EXPLAIN "+strings.TrimRight(strings.TrimSpace(stmt), ";"))
+	if err != nil {
+		tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT dry_run`)
+		return "-- could not explain: " + err.Error()
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "-- could not explain: " + err.Error()
+		}
+		plan = append(plan, "--   "+line)
+	}
+	if err := rows.Err(); err != nil {
+		return "-- could not explain: " + err.Error()
+	}
+	return "-- EXPLAIN:\n" + strings.Join(plan, "\n")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const emfNamespace = "SupabaseMigrate"
+
+// Prints the run metrics as a CloudWatch Embedded Metric Format document.
+// Lambda and ECS ship stdout to CloudWatch Logs, which extracts the metrics
+// from this line without any agent or API call.
+func emitEMF(dbURL string, report *runReport) error {
+	status := "success"
+	failures := 0
+	if report.Error != "" {
+		status = "failure"
+		failures = 1
+	}
+
+	var duration time.Duration
+	if report.FinishedAt != nil {
+		duration = report.FinishedAt.Sub(report.StartedAt)
+	}
+
+	doc := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace":  emfNamespace,
+					"Dimensions": [][]string{{"Project", "Status"}},
+					"Metrics": []map[string]string{
+						{"Name": "Duration", "Unit": "Milliseconds"},
+						{"Name": "MigrationsApplied", "Unit": "Count"},
+						{"Name": "Failures", "Unit": "Count"},
+					},
+				},
+			},
+		},
+		"Project":           projectRef(dbURL),
+		"Status":            status,
+		"Duration":          duration.Milliseconds(),
+		"MigrationsApplied": len(report.Applied),
+		"Failures":          failures,
+		"RunId":             report.RunID,
+		"Versions":          report.Applied,
+	}
+	if report.Error != "" {
+		doc["Error"] = report.Error
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(line))
+	return nil
+}
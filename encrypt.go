@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	historyKeyEnv        = "MIGRATIONS_HISTORY_KEY"
+	historyKeyCommandEnv = "MIGRATIONS_HISTORY_KEY_COMMAND"
+	// Prefix of encrypted statements in the history table
+	encryptedPrefix = "enc:v1:"
+)
+
+// Returns the AES-256 key for the statements column, or nil when history
+// encryption is off. The key is base64 in MIGRATIONS_HISTORY_KEY, or printed by
+// MIGRATIONS_HISTORY_KEY_COMMAND, e.g. a KMS or secrets manager CLI call.
+func loadHistoryKey() ([]byte, error) {
+	encoded := os.Getenv(historyKeyEnv)
+	if command := os.Getenv(historyKeyCommandEnv); encoded == "" && command != "" {
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %v", historyKeyCommandEnv, err)
+		}
+		encoded = string(out)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("history key is not valid base64: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("history key must be 32 bytes (AES-256), got %d", len(key))
+	}
+	return key, nil
+}
+
+// Encrypts each statement with AES-GCM under a random nonce
+func encryptStatements(key []byte, statements []string) ([]string, error) {
+	gcm, err := historyCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]string, len(statements))
+	for i, stmt := range statements {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		sealed := gcm.Seal(nonce, nonce, []byte(stmt), nil)
+		encrypted[i] = encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+	}
+	return encrypted, nil
+}
+
+// Decrypts a statement from the history table; plain statements, written
+// before encryption was turned on, are returned as they are
+func decryptStatement(key []byte, stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if key == nil {
+		return "", fmt.Errorf("statement is encrypted; set %s or %s", historyKeyEnv, historyKeyCommandEnv)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := historyCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted statement is truncated")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt statement (wrong key?): %v", err)
+	}
+	return string(plain), nil
+}
+
+func historyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Returns the label added by an ALTER TYPE ... ADD VALUE statement, quoted as
+// a string literal
+func enumValueAddition(stmt string) (string, bool) {
+	for _, cmd := range splitCommands(stmt) {
+		if !cmd.is(0, "ALTER", "TYPE") {
+			continue
+		}
+		_, i := readQualifiedName(cmd, 2)
+		if !cmd.is(i, "ADD", "VALUE") {
+			continue
+		}
+		i = cmd.skip(i+2, "IF", "NOT", "EXISTS")
+		if i < len(cmd) && strings.HasPrefix(cmd[i], "'") {
+			return cmd[i], true
+		}
+	}
+	return "", false
+}
+
+// Returns the indexes of ALTER TYPE ... ADD VALUE statements that must run
+// outside the migration's transaction. Before PostgreSQL 12 the command can't
+// run in a transaction block at all; from 12 on it can, but the new label
+// can't be used until the transaction commits, so it only has to run outside
+// when a later statement of the same migration uses the label.
+func enumAdditionsOutsideTransaction(statements []string, serverVersion int) map[int]bool {
+	outside := map[int]bool{}
+	for i, stmt := range statements {
+		label, ok := enumValueAddition(stmt)
+		if !ok {
+			continue
+		}
+		if serverVersion < 120000 {
+			outside[i] = true
+			continue
+		}
+	later:
+		for _, later := range statements[i+1:] {
+			for _, tok := range migrate.Tokenize(later) {
+				// Function bodies may use it too
+				if tok == label || (strings.HasPrefix(tok, "$") && strings.Contains(tok, label)) {
+					outside[i] = true
+					break later
+				}
+			}
+		}
+	}
+	return outside
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultEnumsFile = "./supabase/enums.yaml"
+
+// An enum type as declared in enums.yaml, with its labels in sort order
+type enumType struct {
+	Name   string   `yaml:"name"` // schema.name; public when unqualified
+	Values []string `yaml:"values"`
+}
+
+type enumsFile struct {
+	Enums []enumType `yaml:"enums"`
+}
+
+// Loads the declared enums. A missing file means enums aren't managed.
+func loadEnums(path string) ([]enumType, bool, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var f enumsFile
+	if err := yaml.Unmarshal(raw, &f); err != nil {
+		return nil, false, fmt.Errorf("%s: %v", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i, e := range f.Enums {
+		if e.Name == "" || len(e.Values) == 0 {
+			return nil, false, fmt.Errorf("%s: enum %d needs name and values", path, i+1)
+		}
+		if !strings.Contains(e.Name, ".") {
+			f.Enums[i].Name = "public." + e.Name
+		}
+		if seen[f.Enums[i].Name] {
+			return nil, false, fmt.Errorf("%s: duplicate enum %s", path, f.Enums[i].Name)
+		}
+		seen[f.Enums[i].Name] = true
+		labels := map[string]bool{}
+		for _, v := range e.Values {
+			if labels[v] {
+				return nil, false, fmt.Errorf("%s: enum %s lists %q twice", path, e.Name, v)
+			}
+			labels[v] = true
+		}
+	}
+	return f.Enums, true, nil
+}
+
+// Differences between enums.yaml and pg_enum. Labels can be added in place
+// but not removed or reordered, so those are only reported.
+type enumDrift struct {
+	Missing    []enumType // declared but not created
+	Statements []string   // ALTER TYPE ... ADD VALUE for missing labels, in order
+	Extra      []string   // "type: label" present but not declared
+	Misordered []string   // types whose existing labels are in another order
+}
+
+func (d enumDrift) empty() bool {
+	return len(d.Missing) == 0 && len(d.Statements) == 0 && len(d.Extra) == 0 && len(d.Misordered) == 0
+}
+
+// Quotes schema.name for SQL
+func enumIdent(name string) string {
+	schema, typ, _ := strings.Cut(name, ".")
+	return quoteIdentIfNeeded(schema) + "." + quoteIdentIfNeeded(typ)
+}
+
+func enumLiteral(label string) string {
+	return "'" + strings.ReplaceAll(label, "'", "''") + "'"
+}
+
+func diffEnums(ctx context.Context, db *sql.DB, enums []enumType) (enumDrift, error) {
+	var drift enumDrift
+	for _, e := range enums {
+		schema, name, _ := strings.Cut(e.Name, ".")
+		var isEnum bool
+		err := db.QueryRowContext(ctx, `
+			SELECT t.typtype = 'e'
+			FROM pg_type t JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE n.nspname = $1 AND t.typname = $2
+		`, schema, name).Scan(&isEnum)
+		if err == sql.ErrNoRows {
+			drift.Missing = append(drift.Missing, e)
+			continue
+		}
+		if err != nil {
+			return drift, err
+		}
+		if !isEnum {
+			return drift, fmt.Errorf("%s exists but is not an enum", e.Name)
+		}
+
+		current, err := enumLabels(ctx, db, e.Name)
+		if err != nil {
+			return drift, err
+		}
+
+		// Each missing label goes right after the label declared before it,
+		// which exists by then; a missing first label goes before the first
+		// existing one
+		prev := ""
+		for _, v := range e.Values {
+			if !slices.Contains(current, v) {
+				stmt := fmt.Sprintf("ALTER TYPE %s ADD VALUE IF NOT EXISTS %s", enumIdent(e.Name), enumLiteral(v))
+				if prev != "" {
+					stmt += " AFTER " + enumLiteral(prev)
+				} else if len(current) > 0 {
+					stmt += " BEFORE " + enumLiteral(current[0])
+				}
+				drift.Statements = append(drift.Statements, stmt)
+			}
+			prev = v
+		}
+
+		var kept []string
+		for _, v := range current {
+			if slices.Contains(e.Values, v) {
+				kept = append(kept, v)
+			} else {
+				drift.Extra = append(drift.Extra, e.Name+": "+v)
+			}
+		}
+		var declared []string
+		for _, v := range e.Values {
+			if slices.Contains(current, v) {
+				declared = append(declared, v)
+			}
+		}
+		if !slices.Equal(kept, declared) {
+			drift.Misordered = append(drift.Misordered, e.Name)
+		}
+	}
+	return drift, nil
+}
+
+// Labels of an enum in sort order
+func enumLabels(ctx context.Context, db *sql.DB, name string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT e.enumlabel FROM pg_enum e
+		WHERE e.enumtypid = $1::regtype
+		ORDER BY e.enumsortorder
+	`, enumIdent(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var labels []string
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, rows.Err()
+}
+
+// Creates missing enums and adds missing labels in their declared position.
+// Each statement runs on its own, outside any transaction, so the new labels
+// are committed (and usable by the migrations that follow) on every
+// PostgreSQL version. Labels that aren't declared and types whose labels are
+// in another order are only warned about, since enums can't drop or reorder
+// labels in place.
+func reconcileEnums(ctx context.Context, db *sql.DB, enums []enumType) error {
+	drift, err := diffEnums(ctx, db, enums)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range drift.Missing {
+		labels := make([]string, len(e.Values))
+		for i, v := range e.Values {
+			labels[i] = enumLiteral(v)
+		}
+		stmt := fmt.Sprintf("CREATE TYPE %s AS ENUM (%s)", enumIdent(e.Name), strings.Join(labels, ", "))
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("creating enum %s: %v", e.Name, err)
+		}
+		logInfo("Created enum %s.", e.Name)
+	}
+	for _, stmt := range drift.Statements {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+		logInfo("%s", stmt)
+	}
+
+	for _, extra := range drift.Extra {
+		logWarn("Warning: enum label %s is not declared in enums.yaml", extra)
+	}
+	for _, name := range drift.Misordered {
+		logWarn("Warning: the labels of enum %s are in another order than in enums.yaml", name)
+	}
+	if drift.empty() {
+		logInfo("Enums are up to date.")
+	}
+	return nil
+}
+
+// Handles `enums check` (report drift, fail if any) and `enums sync`
+func runEnums(opts options, dbURL string, args []string) (bool, error) {
+	if len(args) != 1 || (args[0] != "check" && args[0] != "sync") {
+		return false, fmt.Errorf("enums requires check or sync")
+	}
+
+	enums, ok, err := loadEnums(opts.enumsFile)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, fmt.Errorf("%s not found", opts.enumsFile)
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	if args[0] == "sync" {
+		return true, reconcileEnums(ctx, db, enums)
+	}
+
+	drift, err := diffEnums(ctx, db, enums)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range drift.Missing {
+		logError("enum %s is declared but doesn't exist", e.Name)
+	}
+	for _, stmt := range drift.Statements {
+		logError("pending: %s", stmt)
+	}
+	for _, extra := range drift.Extra {
+		logError("enum label %s is not declared in enums.yaml", extra)
+	}
+	for _, name := range drift.Misordered {
+		logError("the labels of enum %s are in another order than in enums.yaml", name)
+	}
+	if !drift.empty() {
+		return false, nil
+	}
+	logInfo("%d enums match %s.", len(enums), opts.enumsFile)
+	return true, nil
+}
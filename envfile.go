@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Env files loaded when --env-file isn't given, first match wins per variable
+var defaultEnvFiles = []string{".env.local", ".env"}
+
+// Loads variables from the --env-file file, or from .env.local and .env when
+// they exist, without overriding variables already set in the environment.
+// Runs before the flags are defined so env-backed flag defaults
+// (PRODUCTION_DATABASE_URL, ...) see the loaded values, which is why it finds
+// --env-file in the arguments itself.
+func loadEnvFiles(args []string) error {
+	if path, ok := envFileArg(args); ok {
+		return loadEnvFile(path)
+	}
+	for _, path := range defaultEnvFiles {
+		if err := loadEnvFile(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the value of --env-file (or -env-file) in the arguments
+func envFileArg(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "env-file" {
+			continue
+		}
+		if hasValue {
+			return value, true
+		}
+		if i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// Sets the variables of a dotenv file that aren't set yet. Handles KEY=VALUE
+// lines with an optional export prefix, # comments, and single- or
+// double-quoted values (the latter with \n escapes).
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '"' && strings.LastIndexByte(value, '"') > 0:
+			value = value[1:strings.LastIndexByte(value, '"')]
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value)
+		case len(value) >= 2 && value[0] == '\'' && strings.LastIndexByte(value, '\'') > 0:
+			value = value[1:strings.LastIndexByte(value, '\'')]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Exit codes, so scripts can tell failures apart
+const (
+	exitError           = 1 // anything else, including usage errors
+	exitConnection      = 2 // the database couldn't be reached
+	exitMigrationFailed = 3 // a migration failed and was rolled back
+	exitDrift           = 4 // applied migrations changed since they were applied
+
+	// apply --exit-code-on-pending when there was nothing to apply
+	exitNothingApplied = 10
+)
+
+// Lines of SQL shown around the failing line of a statement
+const errorContext = 2
+
+// Applied migrations whose files changed since they were applied
+type driftError struct {
+	msg string
+}
+
+func (e *driftError) Error() string { return e.msg }
+
+// A statement of a migration that failed, by index in Migration.Statements
+type statementError struct {
+	index int
+	sql   string
+	err   error
+}
+
+func (e *statementError) Error() string {
+	return fmt.Sprintf("statement %d: %v", e.index+1, e.err)
+}
+
+func (e *statementError) Unwrap() error { return e.err }
+
+// A migration that failed to apply, with the statement and SQL that failed
+// when the failure was in one
+type migrationError struct {
+	m   Migration
+	err error
+}
+
+func (e *migrationError) Unwrap() error { return e.err }
+
+// Names the migration, the statement and its line in the file, followed by
+// the SQL around the line PostgreSQL reported
+func (e *migrationError) Error() string {
+	var se *statementError
+	if !errors.As(e.err, &se) {
+		return fmt.Sprintf("migration %s_%s failed: %v", e.m.Version, e.m.Name, e.err)
+	}
+
+	// Line of the statement in the file, when it appears there verbatim
+	// (psql variables and rewrites can change it)
+	first := 0
+	if at := strings.Index(e.m.Raw, strings.TrimSpace(se.sql)); at >= 0 {
+		first = strings.Count(e.m.Raw[:at], "\n") + 1
+	}
+	sqlText := strings.TrimSpace(se.sql)
+	lines := strings.Split(sqlText, "\n")
+
+	// Line of the statement PostgreSQL pointed at, 0-based
+	failing := -1
+	var pgErr *pgconn.PgError
+	if errors.As(se.err, &pgErr) && pgErr.Position > 0 {
+		failing = 0
+		for i, n := 0, int(pgErr.Position)-1; i < len(sqlText) && n > 0; n-- {
+			if sqlText[i] == '\n' {
+				failing++
+			}
+			_, size := utf8.DecodeRuneInString(sqlText[i:])
+			i += size
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "migration %s_%s failed at statement %d of %d", e.m.Version, e.m.Name, se.index+1, len(e.m.Statements))
+	if first > 0 {
+		line := first
+		if failing >= 0 {
+			line += failing
+		}
+		fmt.Fprintf(&b, " (line %d)", line)
+	}
+	fmt.Fprintf(&b, ": %v", se.err)
+
+	from, to := 0, min(len(lines), 2*errorContext+1)
+	if failing >= 0 {
+		from, to = max(0, failing-errorContext), min(len(lines), failing+errorContext+1)
+	}
+	for i := from; i < to; i++ {
+		marker := "  "
+		if i == failing {
+			marker = "> "
+		}
+		number := i + 1
+		if first > 0 {
+			number = first + i
+		}
+		fmt.Fprintf(&b, "\n  %s%4d | %s", marker, number, lines[i])
+	}
+	if to < len(lines) {
+		b.WriteString("\n         | ...")
+	}
+	return b.String()
+}
+
+// Exit code for an error: connection failures, failed migrations and drift
+// have their own; everything else is 1
+func exitCode(err error) int {
+	var connErr *pgconn.ConnectError
+	var migErr *migrationError
+	var drift *driftError
+	var libDrift *migrate.DriftError
+	switch {
+	case errors.As(err, &connErr):
+		return exitConnection
+	case errors.As(err, &migErr):
+		return exitMigrationFailed
+	case errors.As(err, &drift), errors.As(err, &libDrift):
+		return exitDrift
+	default:
+		return exitError
+	}
+}
+
+// Logs the error and exits with its exit code
+func fail(err error) {
+	logError("Error: %v", err)
+	closeLogSinks()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Roles PostgREST serves API requests as on Supabase
+var apiRoles = []string{"anon", "authenticated"}
+
+// Returns the tables the commands of a statement create, as written.
+// Temporary tables are never exposed and are left out.
+func createdTables(stmt string) []string {
+	var tables []string
+	for _, cmd := range splitCommands(stmt) {
+		if !cmd.is(0, "CREATE") {
+			continue
+		}
+		i := cmd.skip(1, "OR", "REPLACE", "GLOBAL", "LOCAL", "UNLOGGED")
+		if cmd.is(i, "TEMP") || cmd.is(i, "TEMPORARY") || !cmd.is(i, "TABLE") {
+			continue
+		}
+		name, _ := readQualifiedName(cmd, cmd.skip(i+1, "IF", "NOT", "EXISTS"))
+		if name != "" {
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// Returns the schemas PostgREST exposes: --exposed-schemas, else the
+// pgrst.db_schemas setting of the authenticator role Supabase configures,
+// else public
+func exposedSchemas(ctx context.Context, db *sql.DB, flagValue string) ([]string, error) {
+	value := flagValue
+	if value == "" {
+		err := db.QueryRowContext(ctx, `
+			SELECT substr(c, length('pgrst.db_schemas=') + 1)
+			FROM pg_roles, unnest(rolconfig) c
+			WHERE rolname = 'authenticator' AND c LIKE 'pgrst.db\_schemas=%'
+		`).Scan(&value)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	var schemas []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			schemas = append(schemas, unquoteIdent(s))
+		}
+	}
+	if len(schemas) == 0 {
+		schemas = []string{"public"}
+	}
+	return schemas, nil
+}
+
+// Handles `check exposure`: tables created by the local migrations (from
+// --since on) that live in a schema PostgREST exposes must not be reachable
+// by the API roles without row level security. Tables with RLS but no
+// policies, and tables the API roles can't reach, are reported without
+// failing. Returns false if any table is exposed without RLS.
+func runCheckExposure(opts options, dbURL string) bool {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+	defer db.Close()
+
+	schemas, err := exposedSchemas(ctx, db, opts.exposedSchemas)
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+	exposed := map[string]bool{}
+	for _, s := range schemas {
+		exposed[s] = true
+	}
+
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+
+	seen := map[string]bool{}
+	checked, problems := 0, 0
+	for _, m := range migrations {
+		if opts.since != "" && m.Version < opts.since {
+			continue
+		}
+		for _, stmt := range m.Statements {
+			for _, table := range createdTables(stmt) {
+				var schema, name string
+				var rls bool
+				var policies int
+				var grantees string
+				err := db.QueryRowContext(ctx, `
+					SELECT n.nspname, c.relname, c.relrowsecurity,
+						(SELECT count(*) FROM pg_policy p WHERE p.polrelid = c.oid),
+						array_to_string(ARRAY(
+							SELECT r.rolname FROM pg_roles r
+							WHERE r.rolname = ANY($2::text[])
+								AND has_table_privilege(r.oid, c.oid, 'SELECT, INSERT, UPDATE, DELETE')
+							ORDER BY r.rolname
+						), ', ')
+					FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+					WHERE c.oid = to_regclass($1)
+				`, table, formatPostgresArray(apiRoles)).Scan(&schema, &name, &rls, &policies, &grantees)
+				if err == sql.ErrNoRows {
+					// Not applied yet, or dropped by a later migration
+					continue
+				}
+				if err != nil {
+					logError("Error: %s: %v", table, err)
+					return false
+				}
+				qualified := schema + "." + name
+				if seen[qualified] || !exposed[schema] {
+					continue
+				}
+				seen[qualified] = true
+				checked++
+
+				switch {
+				case grantees == "":
+					logInfo("%s (%s): not reachable through the API (no grants to %s)", qualified, m.Version, strings.Join(apiRoles, " or "))
+				case !rls:
+					logError("%s (%s): %s can access it through the API without row level security; ENABLE ROW LEVEL SECURITY and add policies, or revoke the grants",
+						qualified, m.Version, grantees)
+					problems++
+				case policies == 0:
+					logWarn("Warning: %s (%s): row level security is on but there are no policies, so the API returns no rows to %s",
+						qualified, m.Version, grantees)
+				}
+			}
+		}
+	}
+
+	if problems > 0 {
+		logError("%d of %d table(s) in exposed schemas (%s) are exposed without row level security.", problems, checked, strings.Join(schemas, ", "))
+		return false
+	}
+	logInfo("%d table(s) in exposed schemas (%s) checked.", checked, strings.Join(schemas, ", "))
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Verifies that the extensions declared with -- requires-extension are
+// installed before anything runs. Missing ones are created when create is
+// set and the server offers them; otherwise, and on hosted plans that don't
+// offer them at all, the run fails with every problem listed.
+func ensureExtensions(ctx context.Context, db *sql.DB, migrations []Migration, create bool, schema string) error {
+	var problems []string
+	seen := map[string]bool{}
+
+	for _, m := range migrations {
+		for _, ext := range m.Directives.RequiresExtensions {
+			if seen[ext] {
+				continue
+			}
+			seen[ext] = true
+
+			var installed, available bool
+			err := db.QueryRowContext(ctx, `
+				SELECT
+					EXISTS(SELECT 1 FROM pg_extension WHERE extname = $1),
+					EXISTS(SELECT 1 FROM pg_available_extensions WHERE name = $1)
+			`, ext).Scan(&installed, &available)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case installed:
+				continue
+			case !available:
+				problems = append(problems, fmt.Sprintf(
+					"extension %s (required by %s) is not available on this server; hosted plans may not offer it", ext, m.Version))
+			case !create:
+				problems = append(problems, fmt.Sprintf(
+					"extension %s (required by %s) is not installed; install it or pass --create-extensions", ext, m.Version))
+			default:
+				stmt := fmt.Sprintf(`CREATE EXTENSION IF NOT EXISTS %s`, pgx.Identifier{ext}.Sanitize())
+				if schema != "" {
+					stmt += fmt.Sprintf(` WITH SCHEMA %s`, pgx.Identifier{schema}.Sanitize())
+				}
+				if _, err := db.ExecContext(ctx, stmt); err != nil {
+					problems = append(problems, fmt.Sprintf(
+						"extension %s (required by %s) could not be created: %v", ext, m.Version, err))
+					continue
+				}
+				logInfo("Created extension %s (required by %s).", ext, m.Version)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("required extensions are missing:\n  - %s", strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Characters that are invalid in file names on at least one of the platforms
+// our runners use (Windows is the strictest)
+const reservedFilenameChars = `<>:"/\|?*`
+
+// Device names Windows refuses to create, whatever the extension
+var reservedWindowsNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Checks that migration filenames behave the same on Linux, macOS and Windows
+// runners: valid UTF-8, no reserved characters or device names, no two files
+// differing only by case, and no two files sharing a version (which would make
+// ordering depend on directory listing order). Reports every offending file
+// at once.
+func validateMigrationFilenames(names []string) error {
+	var problems []string
+
+	byFolded := map[string][]string{}
+	byVersion := map[string][]string{}
+
+	for _, name := range names {
+		if !utf8.ValidString(name) {
+			problems = append(problems, fmt.Sprintf("%q: name is not valid UTF-8", name))
+			continue
+		}
+
+		for _, r := range name {
+			if r < 0x20 || r == 0x7f {
+				problems = append(problems, fmt.Sprintf("%q: contains control character %U", name, r))
+				break
+			}
+			if strings.ContainsRune(reservedFilenameChars, r) {
+				problems = append(problems, fmt.Sprintf("%q: contains reserved character %q", name, r))
+				break
+			}
+		}
+
+		base := strings.TrimSuffix(name, ".sql")
+		if strings.HasSuffix(base, ".") || strings.HasSuffix(base, " ") {
+			problems = append(problems, fmt.Sprintf("%q: name ends with a dot or space", name))
+		}
+		if stem, _, _ := strings.Cut(base, "."); reservedWindowsNames[strings.ToUpper(stem)] {
+			problems = append(problems, fmt.Sprintf("%q: %s is a reserved device name on Windows", name, stem))
+		}
+
+		folded := strings.ToLower(name)
+		byFolded[folded] = append(byFolded[folded], name)
+
+		if version, _, ok := strings.Cut(name, "_"); ok {
+			byVersion[version] = append(byVersion[version], name)
+		}
+	}
+
+	for _, group := range byFolded {
+		if len(group) > 1 {
+			sort.Strings(group)
+			problems = append(problems, fmt.Sprintf("%s: names differ only by case and collide on case-insensitive filesystems",
+				strings.Join(quoteAll(group), ", ")))
+		}
+	}
+	for version, group := range byVersion {
+		if len(group) > 1 {
+			sort.Strings(group)
+			problems = append(problems, fmt.Sprintf("%s: share version %s, so their order is undefined",
+				strings.Join(quoteAll(group), ", "), version))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid migration filenames:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+func quoteAll(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = fmt.Sprintf("%q", n)
+	}
+	return quoted
+}
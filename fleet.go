@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultTargetsFile = "./supabase/targets.yaml"
+	defaultFleetState  = "./supabase/.temp/fleet-state.json"
+)
+
+// A database of the fleet as declared in targets.yaml
+type fleetTarget struct {
+	Name string `yaml:"name"`
+	// Connection string, or the environment variable holding it
+	URL    string `yaml:"url"`
+	URLEnv string `yaml:"url_env"`
+	// psql variables defined for every migration on this target, e.g.
+	// region or tenant_id, referenced as :'region' or :tenant_id
+	Vars map[string]string `yaml:"vars"`
+}
+
+type targetsFile struct {
+	Targets []fleetTarget `yaml:"targets"`
+}
+
+// Loads the targets, resolving url_env
+func loadTargets(path string, opts options) ([]fleetTarget, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no targets file at %s (see --targets)", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f targetsFile
+	if err := yaml.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(f.Targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", path)
+	}
+
+	seen := map[string]bool{}
+	for i, t := range f.Targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("%s: target %d needs a name", path, i+1)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("%s: duplicate target name %s", path, t.Name)
+		}
+		seen[t.Name] = true
+
+		switch {
+		case t.URL != "" && t.URLEnv != "":
+			return nil, fmt.Errorf("%s: target %s has both url and url_env", path, t.Name)
+		case t.URLEnv != "":
+			if f.Targets[i].URL = os.Getenv(t.URLEnv); f.Targets[i].URL == "" {
+				return nil, fmt.Errorf("%s: target %s: %s is not set", path, t.Name, t.URLEnv)
+			}
+		case t.URL == "":
+			return nil, fmt.Errorf("%s: target %s needs url or url_env", path, t.Name)
+		}
+
+		merged, err := mergeSSLParams(f.Targets[i].URL, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: target %s: %v", path, t.Name, err)
+		}
+		f.Targets[i].URL = merged
+		warnWithoutTLS(merged)
+	}
+	return f.Targets, nil
+}
+
+// Outcome of the last fleet apply on a target, kept in --fleet-state
+type fleetOutcome struct {
+	Status  string    `json:"status"` // "ok" or "failed"
+	Error   string    `json:"error,omitempty"`
+	Applied int       `json:"applied"`
+	At      time.Time `json:"at"`
+}
+
+// Reads target name -> last outcome. A missing file is an empty state.
+func readFleetState(path string) (map[string]fleetOutcome, error) {
+	state := map[string]fleetOutcome{}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return state, nil
+}
+
+func writeFleetState(path string, state map[string]fleetOutcome) error {
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
+
+// Handles `fleet apply` and `fleet status`
+func runFleet(opts options, args []string) (bool, error) {
+	if len(args) == 1 {
+		switch args[0] {
+		case "apply":
+			return runFleetApply(opts)
+		case "status":
+			return runFleetStatus(opts)
+		}
+	}
+	return false, fmt.Errorf("usage: fleet apply | fleet status")
+}
+
+// Splits the targets into the canaries named by --canary and the rest.
+// "5%" takes that share of the targets (at least one) in file order; any
+// other value is a comma-separated list of target names.
+func canaryTargets(targets []fleetTarget, spec string) (canary, rest []fleetTarget, err error) {
+	if pct, ok := strings.CutSuffix(spec, "%"); ok {
+		p, err := strconv.ParseFloat(pct, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, nil, fmt.Errorf("--canary %s: expected a percentage between 0 and 100", spec)
+		}
+		n := max(int(math.Ceil(float64(len(targets))*p/100)), 1)
+		n = min(n, len(targets))
+		return targets[:n], targets[n:], nil
+	}
+
+	names := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	for _, t := range targets {
+		if names[t.Name] {
+			canary = append(canary, t)
+			delete(names, t.Name)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	for name := range names {
+		return nil, nil, fmt.Errorf("--canary: %s is not one of the targets to migrate", name)
+	}
+	if len(canary) == 0 {
+		return nil, nil, fmt.Errorf("--canary: no targets given")
+	}
+	return canary, rest, nil
+}
+
+// Applies pending migrations to every target in --targets in turn. A failed
+// target doesn't stop the others. Each outcome is saved to --fleet-state as
+// soon as it is known, so --retry-failed can later run only the targets that
+// failed (or never ran). Returns false if any target failed.
+//
+// With --canary, the canary targets go first, followed by the pgTAP tests in
+// --pgtap on each of them when --canary-tests is set; the rest of the fleet is
+// only migrated if every canary succeeded.
+func runFleetApply(opts options) (bool, error) {
+	targets, err := loadTargets(opts.targetsFile, opts)
+	if err != nil {
+		return false, err
+	}
+	state, err := readFleetState(opts.fleetState)
+	if err != nil {
+		return false, err
+	}
+
+	if opts.retryFailed {
+		var retry []fleetTarget
+		for _, t := range targets {
+			if last, ok := state[t.Name]; !ok || last.Status != "ok" {
+				retry = append(retry, t)
+			}
+		}
+		logInfo("Retrying %d of %d target(s) that failed or haven't run.", len(retry), len(targets))
+		targets = retry
+	}
+
+	canary, rest := []fleetTarget(nil), targets
+	if opts.canary != "" {
+		if canary, rest, err = canaryTargets(targets, opts.canary); err != nil {
+			return false, err
+		}
+	} else if opts.canaryTests {
+		return false, fmt.Errorf("--canary-tests needs --canary")
+	}
+
+	var failed []string
+	done := 0
+	migrate := func(batch []fleetTarget, tests bool) {
+		for _, t := range batch {
+			done++
+			logInfo("== %s (%d/%d) ==", t.Name, done, len(targets))
+			n, err := applyTarget(opts, t)
+			if err == nil && tests {
+				err = canaryCheck(opts, t)
+			}
+			outcome := fleetOutcome{Status: "ok", Applied: n, At: time.Now().UTC()}
+			if err != nil {
+				logError("Error: %s: %v", t.Name, err)
+				failed = append(failed, t.Name)
+				outcome.Status, outcome.Error = "failed", err.Error()
+			}
+			state[t.Name] = outcome
+			if err := writeFleetState(opts.fleetState, state); err != nil {
+				logWarn("Warning: could not write fleet state %s: %v", opts.fleetState, err)
+			}
+		}
+	}
+
+	if len(canary) > 0 {
+		logInfo("Canary: migrating %d of %d target(s) first.", len(canary), len(targets))
+		migrate(canary, opts.canaryTests)
+		if len(failed) > 0 {
+			logError("Canary failed on %s; the other %d target(s) were left alone.", strings.Join(failed, ", "), len(rest))
+			return false, nil
+		}
+		logInfo("Canary succeeded; migrating the other %d target(s).", len(rest))
+	}
+	migrate(rest, false)
+
+	logInfo("Fleet: %d target(s) migrated, %d failed.", len(targets)-len(failed), len(failed))
+	for _, name := range failed {
+		logInfo("  failed: %s", name)
+	}
+	if len(failed) > 0 {
+		logInfo("Run fleet apply --retry-failed to retry only these.")
+	}
+	return len(failed) == 0, nil
+}
+
+// Runs the pgTAP tests on a canary target that was just migrated
+func canaryCheck(opts options, t fleetTarget) error {
+	ok, err := runPgTAP(opts, t.URL)
+	if err != nil {
+		return fmt.Errorf("canary tests: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("canary tests failed")
+	}
+	return nil
+}
+
+// Applies pending migrations to one target with its variables
+func applyTarget(opts options, t fleetTarget) (int, error) {
+	psqlPresets = t.Vars
+	defer func() { psqlPresets = nil }()
+	return runApply(opts, t.URL)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// How many targets fleet status queries at once
+const fleetStatusConcurrency = 8
+
+// Where one target of the fleet is
+type fleetTargetStatus struct {
+	Name string `json:"name"`
+	// Latest applied version, empty if none
+	Version string   `json:"version"`
+	Applied int      `json:"applied"`
+	Pending []string `json:"pending"`
+	// Behind the most advanced target of the fleet
+	Straggler bool          `json:"straggler"`
+	LastRun   *fleetOutcome `json:"last_run,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Reads the history of one target
+func targetStatus(ctx context.Context, t fleetTarget, local []Migration) fleetTargetStatus {
+	s := fleetTargetStatus{Name: t.Name, Pending: []string{}}
+	db, err := sql.Open("pgx", withApplicationName(t.URL))
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	s.Applied = len(applied)
+	for v := range applied {
+		s.Version = max(s.Version, v)
+	}
+	for _, m := range local {
+		if _, ok := applied[m.Version]; !ok && !m.Directives.RunAlways {
+			s.Pending = append(s.Pending, m.Version)
+		}
+	}
+	return s
+}
+
+// Reports which version every target is at, marking the ones behind the most
+// advanced target, as a table, JSON or an HTML page (--format). Returns false
+// if any target is behind or unreachable.
+func runFleetStatus(opts options) (bool, error) {
+	if opts.format != "table" && opts.format != "json" && opts.format != "html" {
+		return false, fmt.Errorf("unknown format %q for fleet status (expected table, json or html)", opts.format)
+	}
+	targets, err := loadTargets(opts.targetsFile, opts)
+	if err != nil {
+		return false, err
+	}
+	state, err := readFleetState(opts.fleetState)
+	if err != nil {
+		return false, err
+	}
+	local, err := loadLocalMigrations()
+	if err != nil {
+		return false, err
+	}
+
+	statuses := make([]fleetTargetStatus, len(targets))
+	g, ctx := errgroup.WithContext(context.Background())
+	g.SetLimit(fleetStatusConcurrency)
+	for i, t := range targets {
+		g.Go(func() error {
+			statuses[i] = targetStatus(ctx, t, local)
+			return nil
+		})
+	}
+	g.Wait()
+
+	newest := ""
+	for _, s := range statuses {
+		newest = max(newest, s.Version)
+	}
+	ok := true
+	for i := range statuses {
+		s := &statuses[i]
+		if last, found := state[s.Name]; found {
+			s.LastRun = &last
+		}
+		s.Straggler = s.Error == "" && s.Version < newest
+		if s.Straggler || s.Error != "" {
+			ok = false
+		}
+	}
+	// Stragglers first, furthest behind first
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].Straggler != statuses[j].Straggler {
+			return statuses[i].Straggler
+		}
+		return statuses[i].Straggler && statuses[i].Version < statuses[j].Version
+	})
+
+	switch opts.format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return ok, enc.Encode(statuses)
+	case "html":
+		return ok, fleetStatusPage.Execute(os.Stdout, map[string]any{
+			"Newest":    newest,
+			"Generated": time.Now().UTC().Format(time.RFC3339),
+			"Targets":   statuses,
+		})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tVERSION\tAPPLIED\tPENDING\tLAST RUN\tSTATUS")
+	for _, s := range statuses {
+		lastRun := "-"
+		if s.LastRun != nil {
+			lastRun = s.LastRun.Status + " " + s.LastRun.At.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", s.Name, orDash(s.Version), s.Applied, len(s.Pending), lastRun, targetState(s))
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+	fmt.Println()
+	behind, unreachable := 0, 0
+	for _, s := range statuses {
+		if s.Straggler {
+			behind++
+		}
+		if s.Error != "" {
+			unreachable++
+		}
+	}
+	logInfo("%d target(s), newest version %s: %d behind, %d unreachable.", len(statuses), orDash(newest), behind, unreachable)
+	return ok, nil
+}
+
+// Summarizes a target for the STATUS column
+func targetState(s fleetTargetStatus) string {
+	switch {
+	case s.Error != "":
+		msg := strings.Join(strings.Fields(s.Error), " ")
+		if len(msg) > 100 {
+			msg = msg[:97] + "..."
+		}
+		return "unreachable: " + msg
+	case s.Straggler:
+		return "behind"
+	default:
+		return "current"
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+var fleetStatusPage = template.Must(template.New("fleet").Funcs(template.FuncMap{
+	"state": targetState,
+	"dash":  orDash,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Fleet status</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+tr.behind { background: #fff3cd; }
+tr.unreachable { background: #f8d7da; }
+</style>
+</head>
+<body>
+<h1>Fleet status</h1>
+<p>Newest version: {{dash .Newest}}. Generated {{.Generated}}.</p>
+<table>
+<tr><th>Target</th><th>Version</th><th>Applied</th><th>Pending</th><th>Last run</th><th>Status</th></tr>
+{{range .Targets}}<tr class="{{if .Error}}unreachable{{else if .Straggler}}behind{{end}}"><td>{{.Name}}</td><td>{{dash .Version}}</td><td>{{.Applied}}</td><td>{{len .Pending}}</td><td>{{with .LastRun}}{{.Status}} {{.At.Format "2006-01-02 15:04"}}{{else}}-{{end}}</td><td>{{state .}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Reports liveness while a single statement runs: every interval it refreshes
+// the heartbeat columns on the lock row and prints how long the statement has
+// been running, so monitors can tell "still working" from "hung".
+// The returned function stops the heartbeat.
+func startHeartbeat(ctx context.Context, lock *leaseLock, version string, pid int, interval time.Duration) func() {
+	if err := lock.setProgress(ctx, version, pid); err != nil {
+		logWarn("Warning: could not update heartbeat: %v", err)
+	}
+	if interval <= 0 {
+		return func() {}
+	}
+
+	started := time.Now()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(started).Round(time.Second)
+				logInfo("Still running migration %s (backend pid %d, elapsed %s)", version, pid, elapsed)
+				if err := lock.setProgress(context.Background(), version, pid); err != nil {
+					logWarn("Warning: could not update heartbeat: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+const (
+	archiveTableName = "schema_migrations_archive"
+	// created_by of the row that stands in for archived migrations
+	baselineCreatedBy = migrate.BaselineCreatedBy
+)
+
+// An archived history row, as exported to --archive-file
+type archivedMigration struct {
+	Version    string          `json:"version"`
+	Name       string          `json:"name"`
+	Hash       string          `json:"hash"`
+	Statements json.RawMessage `json:"statements"`
+	CreatedAt  string          `json:"created_at"`
+	CreatedBy  *string         `json:"created_by"`
+}
+
+// Handles `history show VERSION` and `history archive`, which moves history
+// rows applied more than --months months ago into the archive table, or into
+// --archive-file, and replaces them with a single baseline row listing their
+// versions and hashes, so they still count as applied
+func runHistory(opts options, dbURL string, args []string) error {
+	if len(args) == 2 && args[0] == "show" {
+		return showHistory(dbURL, args[1])
+	}
+	if len(args) != 1 || args[0] != "archive" {
+		return fmt.Errorf("history requires archive or show VERSION")
+	}
+	if opts.archiveMonths <= 0 {
+		return fmt.Errorf("history archive requires --months N")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Don't rewrite the history under a running apply
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	lock, err := acquireMigrationLock(ctx, db, opts)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer lock.release(ctx)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version, name, hash, to_json(statements)::text, created_at::text, created_by
+		FROM %s.%s
+		WHERE created_at < NOW() - make_interval(months => $1)
+			AND created_by IS DISTINCT FROM $2
+		ORDER BY version
+	`, schemaName, tableName), opts.archiveMonths, baselineCreatedBy)
+	if err != nil {
+		return err
+	}
+	var archived []archivedMigration
+	for rows.Next() {
+		var a archivedMigration
+		var statements string
+		if err := rows.Scan(&a.Version, &a.Name, &a.Hash, &statements, &a.CreatedAt, &a.CreatedBy); err != nil {
+			rows.Close()
+			return err
+		}
+		a.Statements = json.RawMessage(statements)
+		archived = append(archived, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(archived) == 0 {
+		logInfo("No migrations applied more than %d months ago.", opts.archiveMonths)
+		return nil
+	}
+
+	// Keep the rows before deleting them
+	if opts.archiveFile != "" {
+		if err := writeArchiveFile(opts.archiveFile, archived); err != nil {
+			return err
+		}
+	} else {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %[1]s.%[3]s (
+				LIKE %[1]s.%[2]s INCLUDING ALL,
+				archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			)
+		`, schemaName, tableName, archiveTableName))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Merge into the existing baseline, if an earlier archive left one
+	entries := map[string]string{}
+	var previous sql.NullString
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT array_to_string(statements, E'\n') FROM %s.%s WHERE created_by = $1
+	`, schemaName, tableName), baselineCreatedBy).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for v, h := range migrate.ParseBaseline(previous.String) {
+		entries[v] = h
+	}
+
+	versions := make([]string, 0, len(archived))
+	for _, a := range archived {
+		entries[a.Version] = a.Hash
+		versions = append(versions, a.Version)
+	}
+
+	if opts.archiveFile == "" {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %[1]s.%[3]s
+			SELECT *, NOW() FROM %[1]s.%[2]s WHERE version = ANY($1::text[])
+		`, schemaName, tableName, archiveTableName), formatPostgresArray(versions))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s.%s WHERE version = ANY($1::text[]) OR created_by = $2`, schemaName, tableName),
+		formatPostgresArray(versions), baselineCreatedBy)
+	if err != nil {
+		return err
+	}
+
+	all := make([]string, 0, len(entries))
+	for v, h := range entries {
+		all = append(all, v+":"+h)
+	}
+	sort.Strings(all)
+	latest := strings.SplitN(all[len(all)-1], ":", 2)[0]
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s.%s (version, name, hash, statements, created_by)
+		VALUES ($1, $2, $3, $4::text[], $5)
+	`, schemaName, tableName),
+		latest,
+		"archived_baseline",
+		migrate.Hash(strings.Join(all, "\n")),
+		formatPostgresArray(all),
+		baselineCreatedBy,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	destination := fmt.Sprintf("%s.%s", schemaName, archiveTableName)
+	if opts.archiveFile != "" {
+		destination = opts.archiveFile
+	}
+	logInfo("Archived %d migrations (%s to %s) to %s; %d versions are now covered by the baseline row.",
+		len(archived), archived[0].Version, archived[len(archived)-1].Version, destination, len(entries))
+	return nil
+}
+
+// Prints the statements recorded for a version, decrypting them with the
+// history key when they were encrypted
+func showHistory(dbURL, version string) error {
+	key, err := loadHistoryKey()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// statement_count is read through to_jsonb as tables that no apply has
+	// upgraded yet don't have it
+	var name, createdAt, statementsJSON, count string
+	err = db.QueryRowContext(context.Background(), fmt.Sprintf(`
+		SELECT name, created_at::text, to_json(statements)::text, COALESCE(to_jsonb(t)->>'statement_count', '')
+		FROM %s.%s t WHERE version = $1
+	`, schemaName, tableName), version).Scan(&name, &createdAt, &statementsJSON, &count)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s is not in the history", version)
+	}
+	if err != nil {
+		return err
+	}
+
+	var statements []string
+	if err := json.Unmarshal([]byte(statementsJSON), &statements); err != nil {
+		return err
+	}
+
+	fmt.Printf("-- %s_%s, applied %s\n", version, name, createdAt)
+	if len(statements) == 0 && count != "" && count != "0" {
+		fmt.Printf("-- %s statement(s) applied; their text was not stored (--no-store-statements)\n", count)
+		return nil
+	}
+	for i, stored := range statements {
+		stmt, err := decryptStatement(key, stored)
+		if err != nil {
+			return fmt.Errorf("statement %d: %v", i+1, err)
+		}
+		if i > 0 {
+			fmt.Println("-- statement-breakpoint")
+		}
+		fmt.Println(stmt)
+	}
+	return nil
+}
+
+// Appends the rows to path as JSON lines and syncs them to disk
+func writeArchiveFile(path string, archived []archivedMigration) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, a := range archived {
+		if err := enc.Encode(a); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultHooksFile = "./supabase/hooks.yaml"
+
+// A post-apply command that migrations trigger with -- triggers-hook
+type hookSpec struct {
+	Command string `yaml:"command"`
+	// Command per --env, replacing command; an empty one skips the hook there
+	Env map[string]string `yaml:"env"`
+}
+
+type hooksFile struct {
+	Hooks map[string]hookSpec `yaml:"hooks"`
+}
+
+// Loads the hooks by name. A missing file means no hooks.
+func loadHooks(path string) (map[string]hookSpec, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f hooksFile
+	if err := yaml.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, h := range f.Hooks {
+		if h.Command == "" && len(h.Env) == 0 {
+			return nil, fmt.Errorf("%s: hook %s needs a command", path, name)
+		}
+	}
+	return f.Hooks, nil
+}
+
+// The command to run for an environment; "" when the hook doesn't apply there
+func (h hookSpec) commandFor(env string) string {
+	if cmd, ok := h.Env[env]; ok && env != "" {
+		return cmd
+	}
+	return h.Command
+}
+
+// Fails if a migration triggers a hook the hooks file doesn't define, so a
+// typo is caught before anything is applied
+func checkHooks(migrations []Migration, hooks map[string]hookSpec, path string) error {
+	for _, m := range migrations {
+		for _, name := range m.Directives.TriggersHooks {
+			if _, ok := hooks[name]; !ok {
+				return fmt.Errorf("migration %s triggers hook %s, which is not defined in %s", m.Version, name, path)
+			}
+		}
+	}
+	return nil
+}
+
+// Runs each hook triggered by a migration that was applied, once, in the
+// order the migrations first trigger them. The command runs under sh -c with
+// MIGRATE_ENV and MIGRATE_VERSIONS (the applied versions that triggered it)
+// set. Every hook runs even if an earlier one failed; the migrations are
+// committed by then, so a failed hook is reported for a manual rerun.
+func runHooks(opts options, hooks map[string]hookSpec, migrations []Migration, applied []string) error {
+	done := map[string]bool{}
+	for _, v := range applied {
+		done[v] = true
+	}
+	var order []string
+	versions := map[string][]string{}
+	for _, m := range migrations {
+		if !done[m.Version] {
+			continue
+		}
+		for _, name := range m.Directives.TriggersHooks {
+			if versions[name] == nil {
+				order = append(order, name)
+			}
+			versions[name] = append(versions[name], m.Version)
+		}
+	}
+
+	var failed []string
+	for _, name := range order {
+		command := hooks[name].commandFor(opts.env)
+		if command == "" {
+			logInfo("Hook %s has no command for environment %s; skipped.", name, opts.env)
+			continue
+		}
+		logInfo("Running hook %s (triggered by %s): %s", name, strings.Join(versions[name], ", "), command)
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Env = append(os.Environ(), "MIGRATE_ENV="+opts.env, "MIGRATE_VERSIONS="+strings.Join(versions[name], ","))
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if opts.output == "json" {
+			cmd.Stdout = os.Stderr
+		}
+		if err := cmd.Run(); err != nil {
+			logError("Hook %s failed: %v", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("hook(s) %s failed after the migrations were applied; rerun them by hand", strings.Join(failed, ", "))
+	}
+	return nil
+}
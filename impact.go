@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// What a command does to an existing relation, for estimating its blast
+// radius
+type impact struct {
+	Action   string // e.g. "rewrites", "scans", "locks", "updates"
+	Relation string
+}
+
+// Returns what each command in a statement does to existing tables. Commands
+// that only create new objects have no impact.
+func statementImpacts(stmt string) []impact {
+	var impacts []impact
+	for _, cmd := range splitCommands(stmt) {
+		impacts = append(impacts, commandImpacts(cmd)...)
+	}
+	return impacts
+}
+
+func commandImpacts(cmd sqlCommand) []impact {
+	switch {
+	case cmd.is(0, "UPDATE"):
+		name, _ := readQualifiedName(cmd, cmd.skip(1, "ONLY"))
+		return []impact{{Action: "updates", Relation: name}}
+	case cmd.is(0, "DELETE", "FROM"):
+		name, _ := readQualifiedName(cmd, cmd.skip(2, "ONLY"))
+		return []impact{{Action: "deletes from", Relation: name}}
+	}
+
+	targets := commandLockTargets(cmd)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	action := "locks"
+	switch {
+	case cmd.is(0, "ALTER", "TABLE"):
+		action = alterTableAction(cmd)
+	case cmd.is(0, "DROP", "TABLE"):
+		action = "drops"
+	case cmd.is(0, "TRUNCATE"):
+		action = "truncates"
+	case cmd.is(0, "VACUUM", "FULL"), cmd.is(0, "CLUSTER"):
+		action = "rewrites"
+	case cmd.is(0, "CREATE"):
+		if cmd.is(1, "INDEX") || cmd.is(1, "UNIQUE", "INDEX") {
+			action = "indexes"
+		}
+	case cmd.is(0, "REINDEX"):
+		action = "reindexes"
+	}
+
+	impacts := []impact{{Action: action, Relation: targets[0].Relation}}
+	for _, t := range targets[1:] {
+		impacts = append(impacts, impact{Action: "locks", Relation: t.Relation})
+	}
+	return impacts
+}
+
+// Classifies an ALTER TABLE by its most expensive subcommand: changing a
+// column type or storage rewrites the table, and new validated constraints or
+// NOT NULL scan it
+func alterTableAction(cmd sqlCommand) string {
+	action := "locks"
+	for i := range cmd {
+		switch {
+		case cmd.is(i, "TYPE") && (cmd.is(i-1, "DATA") || cmd.is(i-2, "ALTER") || cmd.is(i-3, "ALTER", "COLUMN")),
+			cmd.is(i, "SET", "TABLESPACE"), cmd.is(i, "SET", "LOGGED"), cmd.is(i, "SET", "UNLOGGED"):
+			return "rewrites"
+		case cmd.is(i, "SET", "NOT", "NULL"), cmd.is(i, "VALIDATE", "CONSTRAINT"),
+			cmd.is(i, "PRIMARY", "KEY"), cmd.is(i, "UNIQUE"), cmd.is(i, "CHECK"), cmd.is(i, "FOREIGN", "KEY"):
+			action = "scans"
+		}
+	}
+	if action == "scans" && cmd.find(0, "NOT", "VALID") >= 0 && cmd.find(0, "SET", "NOT", "NULL") < 0 {
+		action = "locks"
+	}
+	return action
+}
+
+// Returns the estimated row count and total size (with indexes and TOAST) of
+// a relation from the planner statistics, and false if it doesn't exist yet
+func relationStats(ctx context.Context, db *sql.DB, relation string) (int64, int64, bool, error) {
+	var rows, size int64
+	err := db.QueryRowContext(ctx, `
+		SELECT GREATEST(c.reltuples, COALESCE(s.n_live_tup, 0), 0)::bigint, pg_total_relation_size(c.oid)
+		FROM pg_class c
+		LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+		WHERE c.oid = to_regclass($1)
+	`, relation).Scan(&rows, &size)
+	if err == sql.ErrNoRows {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return rows, size, true, nil
+}
+
+// Lists the pending migrations and, for every statement that touches an
+// existing table, what it does and how big the table is, without running
+// or locking anything. With --unused-days, also shows the evidence that the
+// tables and columns they drop are unused.
+func runPlan(opts options, dbURL string) error {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	replicas, err := openReplicas(opts.replicaURLs)
+	if err != nil {
+		return err
+	}
+	defer closeReplicas(replicas)
+
+	snap, err := readHistorySnapshot(ctx, db)
+	if err != nil {
+		return err
+	}
+	if snap.Runner != nil {
+		return snap.Runner
+	}
+	applied := snap.Applied
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok && !m.Directives.RunAlways {
+			continue
+		}
+		pending++
+		if m.Directives.RunAlways {
+			logInfo("%s (%s, run-always)", m.Version, m.Name)
+		} else {
+			logInfo("%s (%s)", m.Version, m.Name)
+		}
+
+		for i, stmt := range m.Statements {
+			for _, imp := range statementImpacts(stmt) {
+				rows, size, ok, err := relationStats(ctx, db, imp.Relation)
+				switch {
+				case err != nil:
+					logWarn("Warning: could not read statistics for %s: %v", imp.Relation, err)
+				case !ok:
+					logInfo("  statement %d %s %s: new in this plan", i+1, imp.Action, imp.Relation)
+				default:
+					logInfo("  statement %d %s %s: %s, %s rows", i+1, imp.Action, imp.Relation, formatBytes(size), formatCount(rows))
+				}
+			}
+		}
+		if opts.unusedDays > 0 {
+			reportUnused(ctx, db, replicas, m, opts.unusedDays)
+		}
+	}
+
+	if pending == 0 {
+		logInfo("No pending migrations.")
+	}
+	return nil
+}
+
+// Formats a byte count with a binary unit, e.g. 120 GB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.0f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Formats a row count compactly, e.g. 450M
+func formatCount(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.0fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.0fK", float64(n)/1e3)
+	default:
+		return fmt.Sprintf("%d", n)
+	}
+}
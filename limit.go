@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Trims the pending migrations to the next --steps, or to the ones up to and
+// including --to, so a release can be applied in pieces. --to must be the
+// version of a local migration, so a typo fails instead of cutting the
+// release at an arbitrary point.
+func limitPending(pending, local []Migration, to string, steps int) ([]Migration, error) {
+	switch {
+	case to != "" && steps > 0:
+		return nil, fmt.Errorf("apply takes --steps or --to, not both")
+	case steps < 0:
+		return nil, fmt.Errorf("--steps must be positive, got %d", steps)
+	case steps > 0:
+		return pending[:min(steps, len(pending))], nil
+	case to != "":
+		if !slices.ContainsFunc(local, func(m Migration) bool { return m.Version == to }) {
+			return nil, fmt.Errorf("--to %s is not the version of a local migration", to)
+		}
+		n := 0
+		for n < len(pending) && pending[n].Version <= to {
+			n++
+		}
+		return pending[:n], nil
+	}
+	return pending, nil
+}
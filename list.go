@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// A row of `list`
+type listEntry struct {
+	Version string `json:"version"`
+	Name    string `json:"name,omitempty"`
+	Status  string `json:"status"` // applied, pending, hash-mismatch, run-always or missing (applied, no local file)
+	// Recorded hash once applied, the file's hash before
+	Hash      string `json:"hash,omitempty"`
+	AppliedAt string `json:"applied_at,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// When and by whom a version was recorded
+type appliedRecord struct {
+	AppliedAt time.Time
+	CreatedBy string
+}
+
+// Returns the status of every local migration, and of applied versions with
+// no local file, sorted by version
+func listEntries(migrations []Migration, applied map[string]string) []listEntry {
+	var entries []listEntry
+	local := map[string]bool{}
+	for _, m := range migrations {
+		local[m.Version] = true
+		e := listEntry{Version: m.Version, Name: m.Name, Status: "pending", Hash: m.Hash}
+		if m.Directives.RunAlways {
+			e.Status = "run-always"
+		} else if hash, ok := applied[m.Version]; ok {
+			e.Status = "applied"
+			if hash != m.Hash {
+				e.Status = "hash-mismatch"
+			}
+			e.Hash = hash
+		}
+		entries = append(entries, e)
+	}
+	for version, hash := range applied {
+		if !local[version] {
+			entries = append(entries, listEntry{Version: version, Status: "missing", Hash: hash})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Version < entries[j].Version })
+	return entries
+}
+
+// Fetches when and by whom each version in the control table was recorded.
+// Versions archived into a baseline row have no row of their own.
+func fetchAppliedRecords(ctx context.Context, db *sql.DB) (map[string]appliedRecord, error) {
+	records := map[string]appliedRecord{}
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, schemaName+"."+tableName).Scan(&exists); err != nil || !exists {
+		return records, err
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version, created_at, COALESCE(created_by, '')
+		FROM %s.%s
+		WHERE created_at IS NOT NULL
+	`, schemaName, tableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version string
+		var r appliedRecord
+		if err := rows.Scan(&version, &r.AppliedAt, &r.CreatedBy); err != nil {
+			return nil, err
+		}
+		records[version] = r
+	}
+	return records, rows.Err()
+}
+
+// Prints local migrations with their status, filtered by --pending,
+// --applied and --since, as a table, JSON or CSV depending on --format
+func runList(opts options, dbURL string) error {
+	format := opts.format
+	if opts.json {
+		format = "json"
+	}
+	if format != "table" && format != "json" && format != "csv" {
+		return fmt.Errorf("unknown format %q for list (expected table, json or csv)", format)
+	}
+	if opts.pendingOnly && opts.appliedOnly {
+		return fmt.Errorf("--pending and --applied can't be combined")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	records, err := fetchAppliedRecords(ctx, db)
+	if err != nil {
+		return err
+	}
+	saveStateCache(opts.stateCache, dbURL, applied)
+
+	migrations, err := loadLocalMigrationsCached(opts.manifestCache, applied)
+	if err != nil {
+		return err
+	}
+
+	entries := []listEntry{}
+	for _, e := range listEntries(migrations, applied) {
+		if opts.pendingOnly && e.Status != "pending" {
+			continue
+		}
+		if opts.appliedOnly && e.Status != "applied" && e.Status != "hash-mismatch" && e.Status != "missing" {
+			continue
+		}
+		if opts.since != "" && e.Version < opts.since {
+			continue
+		}
+		if r, ok := records[e.Version]; ok && e.Status != "pending" && e.Status != "run-always" {
+			e.AppliedAt = r.AppliedAt.UTC().Format(time.RFC3339)
+			e.CreatedBy = r.CreatedBy
+		}
+		entries = append(entries, e)
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"version", "name", "status", "hash", "applied_at", "created_by"})
+		for _, e := range entries {
+			w.Write([]string{e.Version, e.Name, e.Status, e.Hash, e.AppliedAt, e.CreatedBy})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tNAME\tHASH\tAPPLIED AT\tCREATED BY")
+	for _, e := range entries {
+		// The first 12 hex digits are enough to tell hashes apart
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Version, e.Status, e.Name, e.Hash[:min(12, len(e.Hash))], e.AppliedAt, e.CreatedBy)
+	}
+	return w.Flush()
+}
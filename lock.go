@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const lockTableName = "schema_migrations_lock"
+
+// leaseLock is a single-row lock in the migrations schema. The holder keeps
+// extending expires_at while it runs; if it crashes, the lease simply runs
+// out and the next runner takes the row over without manual cleanup.
+type leaseLock struct {
+	db     *sql.DB
+	holder string
+	lease  time.Duration
+
+	mu   sync.Mutex
+	lost error
+
+	stop chan struct{}
+	done chan struct{}
+
+	advisory *advisoryLock // nil when --advisory-lock-key is 0
+}
+
+// Creates the lock table if it doesn't exist
+func ensureLockTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+			holder TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			current_version TEXT,
+			backend_pid INT
+		)
+	`, schemaName, lockTableName))
+	if err != nil {
+		return err
+	}
+
+	// Lock tables created before heartbeats and `cancel` lack these columns
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s.%s
+			ADD COLUMN IF NOT EXISTS current_version TEXT,
+			ADD COLUMN IF NOT EXISTS backend_pid INT,
+			ADD COLUMN IF NOT EXISTS cancel_requested_at TIMESTAMPTZ
+	`, schemaName, lockTableName))
+	return err
+}
+
+// Unique identity for this runner: host, pid and a random suffix
+func newLockHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// Takes the locks that serialize runners: the advisory lock (unless
+// --advisory-lock-key is 0), waiting up to --advisory-lock-timeout or else
+// --lock-wait, and then the lease. With --no-lock it takes
+// neither and returns a nil lock, whose methods do nothing.
+func acquireMigrationLock(ctx context.Context, db *sql.DB, opts options) (*leaseLock, error) {
+	if opts.noLock {
+		logWarn("Warning: --no-lock: not serializing with other runners")
+		return nil, nil
+	}
+
+	var advisory *advisoryLock
+	if opts.advisoryLockKey != 0 {
+		wait := opts.lockWait
+		if opts.advisoryLockTimeout > 0 {
+			wait = opts.advisoryLockTimeout
+		}
+		var err error
+		if advisory, err = acquireAdvisoryLock(ctx, db, opts.advisoryLockKey, wait); err != nil {
+			return nil, err
+		}
+	}
+	l, err := acquireLeaseLock(ctx, db, opts.lockLease, opts.lockWait)
+	if err != nil {
+		if advisory != nil {
+			advisory.release(ctx)
+		}
+		return nil, err
+	}
+	l.advisory = advisory
+	return l, nil
+}
+
+// Shortest --lock-lease accepted
+const minLockLease = 3 * time.Second
+
+// Acquires the migration lock, waiting up to wait for the current holder to
+// release it or for its lease to expire.
+func acquireLeaseLock(ctx context.Context, db *sql.DB, lease, wait time.Duration) (*leaseLock, error) {
+	// The lease is renewed every third of it; shorter ones would renew in a
+	// tight loop
+	if lease < minLockLease {
+		return nil, fmt.Errorf("--lock-lease must be at least %s, got %s", minLockLease, lease)
+	}
+
+	l := &leaseLock{
+		db:     db,
+		holder: newLockHolder(),
+		lease:  lease,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		acquired, previous, err := l.tryAcquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			if previous != "" {
+				logInfo("Took over expired migration lock from %s.", previous)
+			}
+			go l.keepAlive()
+			return l, nil
+		}
+
+		var holder string
+		var expiresAt time.Time
+		err = db.QueryRowContext(ctx,
+			fmt.Sprintf(`SELECT holder, expires_at FROM %s.%s WHERE id = 1`, schemaName, lockTableName),
+		).Scan(&holder, &expiresAt)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("migration lock is held by %s (lease expires at %s)",
+				holder, expiresAt.Format(time.RFC3339))
+		}
+
+		logInfo("Waiting for migration lock held by %s...", holder)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// Inserts the lock row, or takes it over when the existing lease has expired.
+// Returns the previous holder when a takeover happened.
+func (l *leaseLock) tryAcquire(ctx context.Context) (bool, string, error) {
+	var previous sql.NullString
+	err := l.db.QueryRowContext(ctx,
+		fmt.Sprintf(`
+			WITH prev AS (SELECT holder FROM %[1]s.%[2]s WHERE id = 1)
+			INSERT INTO %[1]s.%[2]s AS l (id, holder, acquired_at, heartbeat_at, expires_at)
+			VALUES (1, $1, NOW(), NOW(), NOW() + make_interval(secs => $2))
+			ON CONFLICT (id) DO UPDATE SET
+				holder = EXCLUDED.holder,
+				acquired_at = EXCLUDED.acquired_at,
+				heartbeat_at = EXCLUDED.heartbeat_at,
+				expires_at = EXCLUDED.expires_at,
+				cancel_requested_at = NULL
+			WHERE l.expires_at < NOW()
+			RETURNING (SELECT holder FROM prev)
+		`, schemaName, lockTableName),
+		l.holder,
+		l.lease.Seconds(),
+	).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, previous.String, nil
+}
+
+// Extends the lease at a third of its length until released
+func (l *leaseLock) keepAlive() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.renew(context.Background()); err != nil {
+				logWarn("Warning: could not renew migration lock: %v", err)
+				l.mu.Lock()
+				if l.lost == nil {
+					l.lost = err
+				}
+				l.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (l *leaseLock) renew(ctx context.Context) error {
+	res, err := l.db.ExecContext(ctx,
+		fmt.Sprintf(`
+			UPDATE %s.%s
+			SET heartbeat_at = NOW(), expires_at = NOW() + make_interval(secs => $2)
+			WHERE id = 1 AND holder = $1
+		`, schemaName, lockTableName),
+		l.holder,
+		l.lease.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("lock was taken over by another runner")
+	}
+	return nil
+}
+
+// Records which migration is running and on which backend, refreshing the
+// heartbeat. An empty version clears the progress columns.
+func (l *leaseLock) setProgress(ctx context.Context, version string, pid int) error {
+	if l == nil {
+		return nil
+	}
+	_, err := l.db.ExecContext(ctx,
+		fmt.Sprintf(`
+			UPDATE %s.%s
+			SET heartbeat_at = NOW(), current_version = NULLIF($2, ''), backend_pid = NULLIF($3, 0)
+			WHERE id = 1 AND holder = $1
+		`, schemaName, lockTableName),
+		l.holder,
+		version,
+		pid,
+	)
+	return err
+}
+
+// Reports whether `cancel` asked this run to stop
+func (l *leaseLock) cancelRequested(ctx context.Context) bool {
+	if l == nil {
+		return false
+	}
+	var requested bool
+	err := l.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT cancel_requested_at IS NOT NULL FROM %s.%s WHERE id = 1 AND holder = $1`, schemaName, lockTableName),
+		l.holder,
+	).Scan(&requested)
+	return err == nil && requested
+}
+
+// Reports whether the lease has been lost since it was acquired
+func (l *leaseLock) Err() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Stops renewing, deletes the lock row if we still hold it and releases the
+// advisory lock
+func (l *leaseLock) release(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+
+	_, err := l.db.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE id = 1 AND holder = $1`, schemaName, lockTableName),
+		l.holder,
+	)
+	if l.advisory != nil {
+		if aerr := l.advisory.release(ctx); err == nil {
+			err = aerr
+		}
+	}
+	return err
+}
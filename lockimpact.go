@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A lock a statement will request on a relation
+type lockTarget struct {
+	Relation string
+	Mode     string // pg_locks mode, e.g. AccessExclusiveLock
+}
+
+// Lock modes each mode conflicts with, from the PostgreSQL documentation
+var lockConflicts = map[string][]string{
+	"AccessShareLock":          {"AccessExclusiveLock"},
+	"RowShareLock":             {"ExclusiveLock", "AccessExclusiveLock"},
+	"RowExclusiveLock":         {"ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ShareUpdateExclusiveLock": {"ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ShareLock":                {"RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ShareRowExclusiveLock":    {"RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"ExclusiveLock":            {"RowShareLock", "RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+	"AccessExclusiveLock":      {"AccessShareLock", "RowShareLock", "RowExclusiveLock", "ShareUpdateExclusiveLock", "ShareLock", "ShareRowExclusiveLock", "ExclusiveLock", "AccessExclusiveLock"},
+}
+
+// LOCK TABLE ... IN <mode> MODE names
+var lockTableModes = map[string]string{
+	"ACCESS SHARE":           "AccessShareLock",
+	"ROW SHARE":              "RowShareLock",
+	"ROW EXCLUSIVE":          "RowExclusiveLock",
+	"SHARE UPDATE EXCLUSIVE": "ShareUpdateExclusiveLock",
+	"SHARE":                  "ShareLock",
+	"SHARE ROW EXCLUSIVE":    "ShareRowExclusiveLock",
+	"EXCLUSIVE":              "ExclusiveLock",
+	"ACCESS EXCLUSIVE":       "AccessExclusiveLock",
+}
+
+// Returns the table locks the lock-heavy DDL commands in a statement take.
+// Commands that only take weak locks (plain DML, CREATE TABLE, ...) add
+// nothing.
+func lockTargets(stmt string) []lockTarget {
+	var targets []lockTarget
+	for _, cmd := range splitCommands(stmt) {
+		targets = append(targets, commandLockTargets(cmd)...)
+	}
+	return targets
+}
+
+func commandLockTargets(tokens sqlCommand) []lockTarget {
+	kw, skip := tokens.is, tokens.skip
+	nameList := func(i int, mode string) []lockTarget {
+		var targets []lockTarget
+		for i < len(tokens) {
+			i = skip(i, "ONLY")
+			name, next := readQualifiedName(tokens, i)
+			if name == "" {
+				break
+			}
+			targets = append(targets, lockTarget{Relation: name, Mode: mode})
+			i = next
+			if i < len(tokens) && tokens[i] == "*" {
+				i++
+			}
+			if i >= len(tokens) || tokens[i] != "," {
+				break
+			}
+			i++
+		}
+		return targets
+	}
+
+	switch {
+	case kw(0, "ALTER", "TABLE"):
+		i := skip(2, "IF", "EXISTS", "ONLY")
+		name, _ := readQualifiedName(tokens, i)
+		targets := []lockTarget{{Relation: name, Mode: "AccessExclusiveLock"}}
+		// New foreign keys also lock the referenced table
+		for j := range tokens {
+			if kw(j, "REFERENCES") {
+				if ref, _ := readQualifiedName(tokens, j+1); ref != "" {
+					targets = append(targets, lockTarget{Relation: ref, Mode: "ShareRowExclusiveLock"})
+				}
+			}
+		}
+		return targets
+	case kw(0, "DROP", "TABLE"):
+		return nameList(skip(2, "IF", "EXISTS"), "AccessExclusiveLock")
+	case kw(0, "TRUNCATE"):
+		return nameList(skip(1, "TABLE"), "AccessExclusiveLock")
+	case kw(0, "VACUUM", "FULL"), kw(0, "CLUSTER"):
+		start := 1
+		if kw(0, "VACUUM") {
+			start = 2
+		}
+		return nameList(skip(start, "VERBOSE", "ANALYZE"), "AccessExclusiveLock")
+	case kw(0, "REINDEX", "TABLE"):
+		if kw(2, "CONCURRENTLY") {
+			return nil
+		}
+		return nameList(2, "ShareLock")
+	case kw(0, "LOCK"):
+		targets := nameList(skip(1, "TABLE"), "AccessExclusiveLock")
+		for j := range tokens {
+			if kw(j, "IN") {
+				var words []string
+				for k := j + 1; k < len(tokens) && !isKeyword(tokens[k], "MODE"); k++ {
+					words = append(words, strings.ToUpper(tokens[k]))
+				}
+				if mode, ok := lockTableModes[strings.Join(words, " ")]; ok {
+					for t := range targets {
+						targets[t].Mode = mode
+					}
+				}
+			}
+		}
+		return targets
+	case kw(0, "CREATE", "INDEX"), kw(0, "CREATE", "UNIQUE", "INDEX"):
+		mode := "ShareLock"
+		for j := range tokens {
+			if kw(j, "CONCURRENTLY") {
+				mode = "ShareUpdateExclusiveLock"
+			}
+			if kw(j, "ON") {
+				name, _ := readQualifiedName(tokens, skip(j+1, "ONLY"))
+				return []lockTarget{{Relation: name, Mode: mode}}
+			}
+		}
+	case kw(0, "CREATE", "TRIGGER"), kw(0, "CREATE", "OR", "REPLACE", "TRIGGER"), kw(0, "CREATE", "CONSTRAINT", "TRIGGER"):
+		for j := range tokens {
+			if kw(j, "ON") {
+				name, _ := readQualifiedName(tokens, j+1)
+				return []lockTarget{{Relation: name, Mode: "ShareRowExclusiveLock"}}
+			}
+		}
+	}
+	return nil
+}
+
+// A session holding a lock that conflicts with one a statement needs
+type blocker struct {
+	PID      int
+	App      string
+	State    string
+	Query    string
+	Duration time.Duration
+	Mode     string
+}
+
+// Lists sessions holding locks on the relation that conflict with the mode
+// the statement needs, in transactions open for at least minAge. Runs on a
+// pooled connection rather than the migration's transaction, so a failing
+// lookup can't abort the migration; the migration's own backend is excluded
+// by pid.
+func findBlockers(ctx context.Context, db *sql.DB, target lockTarget, self int, minAge time.Duration) ([]blocker, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.pid,
+			COALESCE(a.application_name, ''),
+			COALESCE(a.state, ''),
+			COALESCE(a.query, ''),
+			COALESCE(EXTRACT(EPOCH FROM NOW() - COALESCE(a.xact_start, a.query_start)), 0)::float8,
+			l.mode
+		FROM pg_locks l
+		JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'relation'
+			AND l.granted
+			AND l.relation = to_regclass($1)
+			AND l.mode = ANY($2)
+			AND l.pid NOT IN (pg_backend_pid(), $3)
+			AND COALESCE(a.xact_start, a.query_start) <= NOW() - make_interval(secs => $4)
+		ORDER BY 5 DESC
+	`, target.Relation, lockConflicts[target.Mode], self, minAge.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blockers []blocker
+	for rows.Next() {
+		var b blocker
+		var seconds float64
+		if err := rows.Scan(&b.PID, &b.App, &b.State, &b.Query, &seconds, &b.Mode); err != nil {
+			return nil, err
+		}
+		b.Duration = time.Duration(seconds * float64(time.Second))
+		blockers = append(blockers, b)
+	}
+	return blockers, rows.Err()
+}
+
+// Prints the sessions that would block a lock-heavy statement, before it
+// runs. Fails when there are more than maxBlockers of them (negative means
+// never fail).
+func reportBlockers(ctx context.Context, db *sql.DB, self int, stmt string, maxBlockers int) error {
+	for _, target := range lockTargets(stmt) {
+		blockers, err := findBlockers(ctx, db, target, self, 0)
+		if err != nil {
+			logWarn("Warning: could not check for sessions blocking %s: %v", target.Relation, err)
+			continue
+		}
+		if len(blockers) == 0 {
+			continue
+		}
+
+		logWarn("Warning: %s on %s is blocked by %d session(s):", target.Mode, target.Relation, len(blockers))
+		logBlockers(blockers)
+
+		if maxBlockers >= 0 && len(blockers) > maxBlockers {
+			return fmt.Errorf("%d session(s) would block %s on %s (--max-blockers %d)",
+				len(blockers), target.Mode, target.Relation, maxBlockers)
+		}
+	}
+	return nil
+}
+
+// Logs the blockers of a lock, one per line
+func logBlockers(blockers []blocker) {
+	for _, b := range blockers {
+		query := strings.Join(strings.Fields(b.Query), " ")
+		if len(query) > 120 {
+			query = query[:117] + "..."
+		}
+		logWarn("  pid %d (application %q, %s for %s, holds %s): %s",
+			b.PID, b.App, b.State, b.Duration.Round(time.Second), b.Mode, query)
+	}
+}
+
+// Checks, before a migration takes any lock, for transactions open longer
+// than maxAge holding locks its DDL conflicts with. An idle-in-transaction
+// session like that makes the DDL queue behind it, and every query on the
+// table queue behind the DDL. Waits up to wait for them to finish, then fails
+// with the sessions found.
+func checkOldTransactions(ctx context.Context, db *sql.DB, m Migration, self int, maxAge, wait time.Duration) error {
+	seen := map[lockTarget]bool{}
+	var targets []lockTarget
+	for _, stmt := range m.Statements {
+		for _, t := range lockTargets(stmt) {
+			if !seen[t] {
+				seen[t] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(wait)
+	for waited := false; ; waited = true {
+		var old []blocker
+		var on lockTarget
+		for _, target := range targets {
+			blockers, err := findBlockers(ctx, db, target, self, maxAge)
+			if err != nil {
+				logWarn("Warning: could not check for old transactions on %s: %v", target.Relation, err)
+				continue
+			}
+			if len(blockers) > 0 {
+				old, on = blockers, target
+				break
+			}
+		}
+		if len(old) == 0 {
+			if waited {
+				logInfo("Old transactions on the tables of %s finished.", m.Version)
+			}
+			return nil
+		}
+		if time.Now().Before(deadline) {
+			if !waited {
+				logWarn("Warning: waiting up to %s for %d transaction(s) older than %s holding locks on %s to finish:", wait, len(old), maxAge, on.Relation)
+				logBlockers(old)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		logWarn("Warning: %d transaction(s) older than %s hold locks on %s that %s conflicts with:", len(old), maxAge, on.Relation, on.Mode)
+		logBlockers(old)
+		return fmt.Errorf("migration %s: %d transaction(s) older than %s hold locks on %s (--max-existing-tx-age %s)",
+			m.Version, len(old), maxAge, on.Relation, maxAge)
+	}
+}
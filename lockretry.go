@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// How often to try acquiring locks, and how long each attempt may wait
+type lockRetryPolicy struct {
+	Attempts int
+	Timeout  time.Duration
+}
+
+func (p *lockRetryPolicy) String() string {
+	return fmt.Sprintf("%dx %s", p.Attempts, p.Timeout)
+}
+
+// Parses "5x 10s": five attempts with a 10 second lock_timeout each
+func parseLockRetry(s string) (*lockRetryPolicy, error) {
+	count, timeout, ok := strings.Cut(strings.TrimSpace(s), "x")
+	if !ok {
+		return nil, fmt.Errorf("expected ATTEMPTSx TIMEOUT, e.g. 5x 10s, got %q", s)
+	}
+	attempts, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || attempts < 1 {
+		return nil, fmt.Errorf("invalid number of attempts %q", count)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(timeout))
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid lock timeout %q", timeout)
+	}
+	return &lockRetryPolicy{Attempts: attempts, Timeout: d}, nil
+}
+
+// Reports whether err is PostgreSQL giving up on a lock (lock_not_available)
+func isLockTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "55P03"
+}
+
+// Executes a statement, retrying it when it times out waiting for a lock.
+// Inside a transaction each attempt is wrapped in a savepoint so a timed out
+// attempt can be rolled back without losing the earlier statements.
+func execWithLockRetry(ctx context.Context, ex execer, inTx bool, stmt string, policy *lockRetryPolicy) error {
+	if policy == nil {
+		_, err := ex.ExecContext(ctx, stmt)
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if inTx {
+			if _, err := ex.ExecContext(ctx, `SAVEPOINT lock_retry`); err != nil {
+				return err
+			}
+		}
+
+		_, err := ex.ExecContext(ctx, stmt)
+		if err == nil {
+			if inTx {
+				_, err = ex.ExecContext(ctx, `RELEASE SAVEPOINT lock_retry`)
+			}
+			return err
+		}
+		if !isLockTimeout(err) || attempt >= policy.Attempts {
+			return err
+		}
+
+		if inTx {
+			if _, rbErr := ex.ExecContext(ctx, `ROLLBACK TO SAVEPOINT lock_retry`); rbErr != nil {
+				return rbErr
+			}
+		}
+
+		wait := time.Duration(attempt) * time.Second
+		logWarn("Warning: lock not acquired within %s (attempt %d/%d), retrying in %s",
+			policy.Timeout, attempt, policy.Attempts, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+func (l logLevel) String() string {
+	switch l {
+	case levelDebug:
+		return "DEBUG"
+	case levelWarn:
+		return "WARN"
+	case levelError:
+		return "ERROR"
+	default:
+		return "INFO"
+	}
+}
+
+// A destination for log lines. The console only shows info and above (see
+// consoleLevel); other sinks such as the log file receive everything,
+// including per-statement detail.
+type logSink interface {
+	write(level logLevel, msg string)
+	close() error
+}
+
+// A sink that also takes the name and fields of structured events, such as
+// the console under --output json. Other sinks get the message only.
+type eventSink interface {
+	writeEvent(level logLevel, event string, fields logFields, msg string)
+}
+
+// Fields of a structured event, e.g. the version of the migration it concerns
+type logFields map[string]interface{}
+
+var (
+	logMu    sync.Mutex
+	logSinks = []logSink{consoleSink{}}
+
+	// Lowest level the console shows: debug with --verbose, warnings with --quiet
+	consoleLevel = levelInfo
+)
+
+// Makes the console print JSON lines instead of plain text (--output json)
+func useJSONConsole() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logSinks[0] = jsonSink{}
+}
+
+func addLogSink(s logSink) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logSinks = append(logSinks, s)
+}
+
+func closeLogSinks() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	for _, s := range logSinks {
+		s.close()
+	}
+}
+
+func logAt(level logLevel, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logMu.Lock()
+	defer logMu.Unlock()
+	for _, s := range logSinks {
+		s.write(level, msg)
+	}
+}
+
+// Logs a structured event. Event sinks receive its name and fields next to
+// the message; other sinks log the message as usual.
+func logEvent(level logLevel, event string, fields logFields, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logMu.Lock()
+	defer logMu.Unlock()
+	for _, s := range logSinks {
+		if es, ok := s.(eventSink); ok {
+			es.writeEvent(level, event, fields, msg)
+		} else {
+			s.write(level, msg)
+		}
+	}
+}
+
+func logDebug(format string, args ...interface{}) { logAt(levelDebug, format, args...) }
+func logInfo(format string, args ...interface{})  { logAt(levelInfo, format, args...) }
+func logWarn(format string, args ...interface{})  { logAt(levelWarn, format, args...) }
+func logError(format string, args ...interface{}) { logAt(levelError, format, args...) }
+
+type consoleSink struct{}
+
+func (consoleSink) write(level logLevel, msg string) {
+	if level < consoleLevel {
+		return
+	}
+	fmt.Println(msg)
+}
+
+func (consoleSink) close() error { return nil }
+
+// Console sink for --output json: one object per line with time, level and
+// msg, plus event and its fields for structured events. Events are printed
+// whatever their level unless --quiet is set; plain debug lines need
+// --verbose.
+type jsonSink struct{}
+
+func (s jsonSink) write(level logLevel, msg string) {
+	s.writeEvent(level, "", nil, msg)
+}
+
+func (jsonSink) writeEvent(level logLevel, event string, fields logFields, msg string) {
+	if level < consoleLevel && (event == "" || consoleLevel > levelInfo) {
+		return
+	}
+	line := map[string]interface{}{}
+	for k, v := range fields {
+		line[k] = v
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = strings.ToLower(level.String())
+	line["msg"] = msg
+	if event != "" {
+		line["event"] = event
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not encode log line: %v\n", err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
+func (jsonSink) close() error { return nil }
+
+// Log file that rolls over to path.1, path.2, ... once it reaches maxSize,
+// keeping at most maxBackups old files.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(r.path, 0); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) write(level logLevel, msg string) {
+	var b strings.Builder
+	prefix := fmt.Sprintf("%s %-5s ", time.Now().UTC().Format(time.RFC3339Nano), level)
+	for _, line := range strings.Split(msg, "\n") {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(b.Len()) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not rotate log file: %v\n", err)
+			return
+		}
+	}
+
+	n, err := r.f.WriteString(b.String())
+	r.size += int64(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write log file: %v\n", err)
+	}
+}
+
+func (r *rotatingFile) close() error {
+	return r.f.Close()
+}
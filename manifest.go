@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultManifestCache = "./supabase/.temp/migrations-manifest.json"
+
+// What was known about a migration file the last time it was read
+type manifestEntry struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Hash      string    `json:"hash"`
+	RunAlways bool      `json:"run_always,omitempty"`
+}
+
+// Directory-level cache of migration hashes, keyed by filename
+type migrationManifest struct {
+	WrittenAt time.Time                `json:"written_at"`
+	Files     map[string]manifestEntry `json:"files"`
+}
+
+// Reads the manifest, or returns an empty one if it's missing or unreadable
+func readManifest(path string) migrationManifest {
+	var m migrationManifest
+	if raw, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(raw, &m)
+	}
+	if m.Files == nil {
+		m.Files = map[string]manifestEntry{}
+	}
+	return m
+}
+
+func (m migrationManifest) write(path string) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Loads local migrations like loadLocalMigrations, but doesn't read applied
+// migrations whose file is unchanged (same size and modification time) since
+// the manifest at path was written; their hash comes from the manifest and
+// they carry no SQL. Pending and run-always migrations are always read. The
+// manifest is rewritten afterwards. An empty path disables the cache.
+func loadLocalMigrationsCached(path string, applied map[string]string) ([]Migration, error) {
+	if path == "" {
+		return loadLocalMigrations()
+	}
+
+	manifest := readManifest(path)
+	reused := 0
+	migrations, err := loadMigrations(func(f os.DirEntry) (Migration, bool) {
+		e, ok := manifest.Files[f.Name()]
+		if !ok || e.RunAlways {
+			return Migration{}, false
+		}
+		info, err := f.Info()
+		if err != nil || info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+			return Migration{}, false
+		}
+		// A file modified just before the manifest was written may have
+		// changed again within the filesystem's timestamp resolution
+		if !e.ModTime.Before(manifest.WrittenAt.Add(-2 * time.Second)) {
+			return Migration{}, false
+		}
+		version, name, _ := strings.Cut(f.Name(), "_")
+		if hash, ok := applied[version]; !ok || hash != e.Hash {
+			return Migration{}, false
+		}
+		reused++
+		return Migration{Version: version, Name: name, Hash: e.Hash}, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	logDebug("Reused %d of %d migrations from %s.", reused, len(migrations), path)
+
+	fresh := migrationManifest{WrittenAt: time.Now(), Files: map[string]manifestEntry{}}
+	for _, m := range migrations {
+		filename := m.Version + "_" + m.Name
+		info, err := os.Stat(filepath.Join(migrationsDir, filename))
+		if err != nil {
+			continue
+		}
+		fresh.Files[filename] = manifestEntry{
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			Hash:      m.Hash,
+			RunAlways: m.Directives.RunAlways,
+		}
+	}
+	if err := fresh.write(path); err != nil {
+		logDebug("Could not write %s: %v", path, err)
+	}
+	return migrations, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Materialized views queued for a refresh because an applied migration
+// changed a relation they read from. A row stays queued until its refresh
+// succeeds, so one that failed is retried on the next apply.
+const refreshTableName = "schema_migrations_refreshes"
+
+func ensureRefreshTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			matview text PRIMARY KEY,
+			depth integer NOT NULL,
+			requested_by text NOT NULL,
+			requested_at timestamptz NOT NULL DEFAULT now(),
+			refreshed_at timestamptz
+		)
+	`, schemaName, refreshTableName))
+	return err
+}
+
+// Materialized views reading from a relation, directly or through views,
+// with how many views deep they are
+const dependentMatviewsQuery = `
+	WITH RECURSIVE deps(oid, depth) AS (
+		SELECT r.ev_class, 1
+		FROM pg_depend d JOIN pg_rewrite r ON r.oid = d.objid
+		WHERE d.classid = 'pg_rewrite'::regclass AND d.refclassid = 'pg_class'::regclass
+			AND d.refobjid = to_regclass($1) AND r.ev_class <> d.refobjid
+		UNION
+		SELECT r.ev_class, deps.depth + 1
+		FROM deps
+		JOIN pg_depend d ON d.refclassid = 'pg_class'::regclass AND d.refobjid = deps.oid
+		JOIN pg_rewrite r ON r.oid = d.objid AND d.classid = 'pg_rewrite'::regclass
+		WHERE r.ev_class <> deps.oid AND deps.depth < 50
+	)
+	SELECT c.oid::regclass::text, max(deps.depth)
+	FROM deps JOIN pg_class c ON c.oid = deps.oid
+	WHERE c.relkind = 'm'
+	GROUP BY c.oid
+`
+
+// Returns the existing relations a migration writes to or changes: the
+// tables its DML and DDL touch and the tables of its backfills
+func writtenRelations(m Migration) []string {
+	seen := map[string]bool{}
+	var relations []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			relations = append(relations, name)
+		}
+	}
+	for _, stmt := range m.Statements {
+		for _, cmd := range splitCommands(stmt) {
+			switch {
+			case cmd.is(0, "INSERT", "INTO"), cmd.is(0, "MERGE", "INTO"):
+				name, _ := readQualifiedName(cmd, cmd.skip(2, "ONLY"))
+				add(name)
+				continue
+			case cmd.is(0, "COPY"):
+				name, _ := readQualifiedName(cmd, 1)
+				if cmd.find(0, "FROM") >= 0 {
+					add(name)
+				}
+				continue
+			}
+			for _, im := range commandImpacts(cmd) {
+				if im.Action != "drops" {
+					add(im.Relation)
+				}
+			}
+		}
+	}
+	for _, b := range m.Directives.Backfills {
+		add(b.Table)
+	}
+	return relations
+}
+
+// Queues the materialized views depending on what the migrations changed
+func queueRefreshes(ctx context.Context, db *sql.DB, migrations []Migration) error {
+	for _, m := range migrations {
+		for _, rel := range writtenRelations(m) {
+			rows, err := db.QueryContext(ctx, dependentMatviewsQuery, rel)
+			if err != nil {
+				return err
+			}
+			type matview struct {
+				name  string
+				depth int
+			}
+			var found []matview
+			for rows.Next() {
+				var mv matview
+				if err := rows.Scan(&mv.name, &mv.depth); err != nil {
+					rows.Close()
+					return err
+				}
+				found = append(found, mv)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, mv := range found {
+				if _, err := db.ExecContext(ctx, fmt.Sprintf(`
+					INSERT INTO %s.%s (matview, depth, requested_by) VALUES ($1, $2, $3)
+					ON CONFLICT (matview) DO UPDATE SET
+						depth = GREATEST(%[2]s.depth, EXCLUDED.depth),
+						requested_by = EXCLUDED.requested_by,
+						requested_at = now(),
+						refreshed_at = NULL
+				`, schemaName, refreshTableName), mv.name, mv.depth, m.Version); err != nil {
+					return err
+				}
+				logDebug("Queued a refresh of %s: %s (%s) changes %s.", mv.name, m.Version, m.Name, rel)
+			}
+		}
+	}
+	return nil
+}
+
+// Refreshes the queued materialized views once each, views read by other
+// queued views first, every refresh in its own transaction. With
+// concurrently, views that are populated and have a unique index are
+// refreshed CONCURRENTLY so readers aren't blocked; others are refreshed
+// plainly. A failed refresh only warns and stays queued for the next apply.
+func refreshMatviews(ctx context.Context, db *sql.DB, lock *leaseLock, concurrently bool) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT q.matview, to_regclass(q.matview) IS NOT NULL,
+			COALESCE(c.relispopulated, false),
+			EXISTS (
+				SELECT 1 FROM pg_index i
+				WHERE i.indrelid = c.oid AND i.indisunique AND i.indisvalid
+					AND i.indpred IS NULL AND 0 <> ALL (i.indkey::int2[])
+			)
+		FROM %s.%s q LEFT JOIN pg_class c ON c.oid = to_regclass(q.matview)
+		WHERE q.refreshed_at IS NULL
+		ORDER BY q.depth, q.matview
+	`, schemaName, refreshTableName))
+	if err != nil {
+		return err
+	}
+	type queued struct {
+		name                      string
+		exists, populated, unique bool
+	}
+	var pending []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.name, &q.exists, &q.populated, &q.unique); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	markDone := fmt.Sprintf(`UPDATE %s.%s SET refreshed_at = now() WHERE matview = $1`, schemaName, refreshTableName)
+	for _, q := range pending {
+		if err := lock.Err(); err != nil {
+			return fmt.Errorf("lost migration lock: %v", err)
+		}
+		if !q.exists {
+			// Dropped since it was queued
+			if _, err := db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s.%s WHERE matview = $1`, schemaName, refreshTableName), q.name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stmt := "REFRESH MATERIALIZED VIEW " + q.name
+		concurrent := concurrently && q.populated && q.unique
+		if concurrent {
+			stmt = "REFRESH MATERIALIZED VIEW CONCURRENTLY " + q.name
+		} else if concurrently {
+			logWarn("Warning: refreshing %s without CONCURRENTLY: it needs to be populated and have a unique index.", q.name)
+		}
+		started := time.Now()
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			logWarn("Warning: could not refresh %s, it will be retried on the next apply: %v", q.name, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, markDone, q.name); err != nil {
+			return err
+		}
+		logEvent(levelInfo, "matview_refreshed", logFields{"matview": q.name, "concurrently": concurrent, "duration_ms": time.Since(started).Milliseconds()},
+			"Refreshed materialized view %s in %s.", q.name, roundDuration(time.Since(started)))
+	}
+	return nil
+}
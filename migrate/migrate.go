@@ -0,0 +1,203 @@
+// Package migrate applies Supabase migrations from Go programs, recording them
+// in supabase_migrations.schema_migrations exactly like the
+// supabase-direct-migrate CLI and the Supabase CLI, so the three can be mixed
+// on the same database:
+//
+//	result, err := migrate.New(db, migrate.Dir("supabase/migrations")).Up(ctx)
+//
+// Migrations run in order, each in its own transaction together with its
+// history row, unless it is marked -- no-transaction. The directives that need
+// the CLI's machinery (-- run-as, -- run-always, -- realtime, -- backfill,
+// -- skip-if, -- template, -- lock-retry, -- requires-extension, -- parallel,
+// -- partitions, -- triggers-hook) and psql meta-commands such as \set are
+// refused rather than silently ignored.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	SchemaName = "supabase_migrations"
+	TableName  = "schema_migrations"
+
+	// created_by of the baseline row `history archive` leaves in place of
+	// archived migrations
+	BaselineCreatedBy = "supabase-direct-migrate archive"
+
+	// pg_advisory_lock key runners take, "supamig" as a big-endian integer
+	DefaultLockKey int64 = 32498747853072743
+)
+
+// A migration file, {version}_{name}.sql
+type Migration struct {
+	Version string
+	Name    string
+	Raw     string
+	Hash    string
+	// Statements applied, without a -- down section
+	Statements []string
+	// Run statements one by one outside a transaction
+	NoTransaction bool
+}
+
+// Runs statements and queries; satisfied by *sql.DB, *sql.Conn and *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SHA-256 of the file contents, same as Supabase
+func Hash(raw string) string {
+	h := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(h[:])
+}
+
+var downMarker = regexp.MustCompile(`(?mi)^--\s*down\s*$`)
+
+// Splits a migration at its -- down line into the part applied and the part
+// that reverts it
+func SplitDown(sql string) (string, string, bool) {
+	loc := downMarker.FindStringIndex(sql)
+	if loc == nil {
+		return sql, "", false
+	}
+	return sql[:loc[0]], sql[loc[1]:], true
+}
+
+// Parses the statements of a baseline row: one "version:hash" entry per
+// archived migration
+func ParseBaseline(statements string) map[string]string {
+	entries := map[string]string{}
+	for _, line := range strings.Split(statements, "\n") {
+		if version, hash, ok := strings.Cut(line, ":"); ok {
+			entries[version] = hash
+		}
+	}
+	return entries
+}
+
+// Parses a migration from its filename ({version}_{name}.sql) and contents
+func Parse(filename, raw string) (Migration, error) {
+	version, name, ok := strings.Cut(filename, "_")
+	if !ok {
+		return Migration{}, fmt.Errorf("invalid migration name: %s", filename)
+	}
+	up, _, _ := SplitDown(raw)
+	m := Migration{
+		Version:    version,
+		Name:       name,
+		Raw:        raw,
+		Hash:       Hash(raw),
+		Statements: SplitStatements(up),
+	}
+	for n, line := range strings.Split(up, "\n") {
+		line = strings.TrimSpace(line)
+		if psqlMetaCommand.MatchString(line) {
+			return Migration{}, fmt.Errorf("%s:%d: psql meta-command %s needs the supabase-direct-migrate CLI", filename, n+1, strings.Fields(line)[0])
+		}
+		match := directiveLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		switch match[1] {
+		case "no-transaction":
+			m.NoTransaction = true
+		case "run-as", "run-always", "realtime", "backfill", "skip-if", "template",
+			"lock-retry", "requires-extension", "parallel", "end-parallel", "partitions",
+			"triggers-hook":
+			return Migration{}, fmt.Errorf("%s: -- %s needs the supabase-direct-migrate CLI", filename, match[1])
+		}
+	}
+	return m, nil
+}
+
+var directiveLine = regexp.MustCompile(`^--\s*([a-z][a-z-]*)\s*(?::\s*(.*))?$`)
+
+// A psql meta-command line, e.g. \set schema app; the CLI expands \set and
+// :name references, the server would reject them
+var psqlMetaCommand = regexp.MustCompile(`^\\[a-zA-Z]`)
+
+// Creates the history table if it doesn't exist
+func EnsureTable(ctx context.Context, ex Execer) error {
+	if _, err := ex.ExecContext(ctx, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, SchemaName)); err != nil {
+		return fmt.Errorf("error creating schema: %v", err)
+	}
+	_, err := ex.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			version TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			statements TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			created_by TEXT,
+			idempotency_key TEXT
+		)
+	`, SchemaName, TableName))
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+	_, err = ex.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s.%s
+			ADD COLUMN IF NOT EXISTS statement_count INT,
+			ADD COLUMN IF NOT EXISTS skipped_reason TEXT,
+			ADD COLUMN IF NOT EXISTS out_of_order_after TEXT
+	`, SchemaName, TableName))
+	if err != nil {
+		return fmt.Errorf("error upgrading table: %v", err)
+	}
+	return nil
+}
+
+// Fetches version -> hash of applied migrations. A database that has never
+// been migrated (no history table yet) has no applied migrations.
+func Applied(ctx context.Context, ex Execer) (map[string]string, error) {
+	var exists bool
+	err := ex.QueryRowContext(ctx,
+		`SELECT to_regclass($1) IS NOT NULL`, SchemaName+"."+TableName).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[string]string{}
+	if !exists {
+		return applied, nil
+	}
+
+	// A baseline row left by `history archive` stands in for the archived
+	// migrations and lists their versions and hashes
+	rows, err := ex.QueryContext(ctx,
+		fmt.Sprintf(`
+			SELECT version, hash, CASE WHEN created_by = $1 THEN array_to_string(statements, E'\n') END
+			FROM %s.%s
+		`, SchemaName, TableName),
+		BaselineCreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version string
+		var hash string
+		var baseline sql.NullString
+		if err := rows.Scan(&version, &hash, &baseline); err != nil {
+			return nil, err
+		}
+		if baseline.Valid {
+			for v, h := range ParseBaseline(baseline.String) {
+				applied[v] = h
+			}
+			continue
+		}
+		applied[version] = hash
+	}
+	return applied, rows.Err()
+}
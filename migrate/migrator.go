@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Applies the migrations of a Source to a database
+type Migrator struct {
+	db     *sql.DB
+	source Source
+
+	// pg_advisory_lock key serializing runners, shared with the CLI by
+	// default; 0 disables the lock
+	LockKey int64
+	// created_by recorded in the history
+	CreatedBy string
+}
+
+// Returns a Migrator for the migrations in source
+func New(db *sql.DB, source Source) *Migrator {
+	return &Migrator{
+		db:        db,
+		source:    source,
+		LockKey:   DefaultLockKey,
+		CreatedBy: "supabase-direct-migrate",
+	}
+}
+
+// What Up did
+type Result struct {
+	// Versions applied, in order
+	Applied []string
+	// How many migrations were already applied
+	Skipped  int
+	Duration time.Duration
+}
+
+// Returned by Up when applied migrations changed since they were applied
+type DriftError struct {
+	Versions []string
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("applied migration(s) changed since they were applied: %s", strings.Join(e.Versions, ", "))
+}
+
+// Returns the migrations that aren't applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	migrations, err := m.source.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := Applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	pending, _, err := split(migrations, applied)
+	return pending, err
+}
+
+// Splits migrations into the pending ones and the number already applied,
+// failing on drift
+func split(migrations []Migration, applied map[string]string) ([]Migration, int, error) {
+	var pending []Migration
+	var changed []string
+	for _, mig := range migrations {
+		hash, ok := applied[mig.Version]
+		switch {
+		case !ok:
+			pending = append(pending, mig)
+		case hash != mig.Hash:
+			changed = append(changed, mig.Version)
+		}
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		return nil, 0, &DriftError{Versions: changed}
+	}
+	return pending, len(migrations) - len(pending), nil
+}
+
+// Applies every pending migration in order. A failed migration is rolled
+// back (unless it is -- no-transaction) and stops the run; the ones before
+// it stay applied and are listed in the result.
+func (m *Migrator) Up(ctx context.Context) (*Result, error) {
+	start := time.Now()
+	result := &Result{}
+
+	migrations, err := m.source.Migrations()
+	if err != nil {
+		return result, err
+	}
+	if err := EnsureTable(ctx, m.db); err != nil {
+		return result, err
+	}
+
+	// Everything runs on one session so it holds the advisory lock
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	if m.LockKey != 0 {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, m.LockKey); err != nil {
+			return result, fmt.Errorf("error acquiring migration lock: %v", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, m.LockKey)
+	}
+
+	applied, err := Applied(ctx, conn)
+	if err != nil {
+		return result, err
+	}
+	pending, skipped, err := split(migrations, applied)
+	if err != nil {
+		return result, err
+	}
+	result.Skipped = skipped
+
+	for _, mig := range pending {
+		if err := m.apply(ctx, conn, mig); err != nil {
+			result.Duration = time.Since(start)
+			return result, fmt.Errorf("migration %s (%s): %v", mig.Version, mig.Name, err)
+		}
+		result.Applied = append(result.Applied, mig.Version)
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// Runs a migration's statements and records it, in one transaction unless it
+// is -- no-transaction
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	if mig.NoTransaction {
+		for i, stmt := range mig.Statements {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("statement %d: %v", i+1, err)
+			}
+		}
+		return m.record(ctx, conn, mig)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, stmt := range mig.Statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("statement %d: %v", i+1, err)
+		}
+	}
+	if err := m.record(ctx, tx, mig); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Inserts the history row of a migration
+func (m *Migrator) record(ctx context.Context, ex Execer, mig Migration) error {
+	statements := mig.Statements
+	if statements == nil {
+		statements = []string{}
+	}
+	_, err := ex.ExecContext(ctx,
+		fmt.Sprintf(`
+			INSERT INTO %s.%s
+				(version, name, hash, statements, created_by, idempotency_key, statement_count)
+			VALUES
+				($1, $2, $3, $4, $5, NULL, $6)
+		`, SchemaName, TableName),
+		mig.Version,
+		mig.Name,
+		mig.Hash,
+		statements,
+		m.CreatedBy,
+		len(mig.Statements),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording migration: %v", err)
+	}
+	return nil
+}
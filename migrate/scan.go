@@ -0,0 +1,122 @@
+package migrate
+
+import "strings"
+
+// The SQL scanner shared by the statement splitter and the CLI's checks, so
+// they agree on where strings, quoted identifiers, comments and dollar-quoted
+// bodies start and end.
+
+// Returns the index just past the block comment starting at sql[i]. Block
+// comments nest in PostgreSQL.
+func SkipBlockComment(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		if strings.HasPrefix(sql[i:], "/*") {
+			depth++
+			i += 2
+		} else if strings.HasPrefix(sql[i:], "*/") {
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		} else {
+			i++
+		}
+	}
+	return i
+}
+
+// Returns the opening tag ($$ or $name$) if s starts with a dollar quote
+func DollarQuoteTag(s string) (string, bool) {
+	if !strings.HasPrefix(s, "$") {
+		return "", false
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		isIdent := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 1 && c >= '0' && c <= '9')
+		if !isIdent {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// Returns the index just past the string or quoted identifier starting at
+// sql[i], and whether it was closed. Doubled quotes stay inside; so do
+// backslash-escaped ones in E'...' strings.
+func SkipQuoted(sql string, i int) (int, bool) {
+	q := sql[i]
+	escapes := q == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i == 1 || !IsWordByte(sql[i-2]))
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case escapes && sql[j] == '\\':
+			j++
+		case sql[j] == q:
+			if j+1 < len(sql) && sql[j+1] == q {
+				j++
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(sql), false
+}
+
+// Reports whether c can be part of an unquoted identifier or keyword
+func IsWordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// Splits SQL into tokens, skipping whitespace and comments. Words keep their
+// case, quoted identifiers keep their quotes, and string literals and
+// dollar-quoted bodies are single tokens.
+func Tokenize(sql string) []string {
+	var tokens []string
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = SkipBlockComment(sql, i)
+		case c == '\'' || c == '"':
+			end, _ := SkipQuoted(sql, i)
+			tokens = append(tokens, sql[i:end])
+			i = end
+		case c == '$':
+			if tag, ok := DollarQuoteTag(sql[i:]); ok {
+				closing := strings.Index(sql[i+len(tag):], tag)
+				end := len(sql)
+				if closing >= 0 {
+					end = i + len(tag) + closing + len(tag)
+				}
+				tokens = append(tokens, sql[i:end])
+				i = end
+				continue
+			}
+			tokens = append(tokens, "$")
+			i++
+		case IsWordByte(c):
+			end := i + 1
+			for end < len(sql) && (IsWordByte(sql[end]) || sql[end] == '$') {
+				end++
+			}
+			tokens = append(tokens, sql[i:end])
+			i = end
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Where migrations come from
+type Source interface {
+	// Returns the migrations sorted by version
+	Migrations() ([]Migration, error)
+}
+
+// A directory of {version}_{name}.sql files in a file system
+type fsSource struct {
+	fsys fs.FS
+	dir  string
+}
+
+// Reads migrations from a directory on disk, e.g. supabase/migrations.
+// {version}_{name}.down.sql files are skipped.
+func Dir(path string) Source {
+	return FS(os.DirFS(path), ".")
+}
+
+// Reads migrations from a directory of a file system, e.g. one embedded in
+// the binary:
+//
+//	//go:embed supabase/migrations/*.sql
+//	var migrations embed.FS
+//
+//	migrate.New(db, migrate.FS(migrations, "supabase/migrations"))
+func FS(fsys fs.FS, dir string) Source {
+	return fsSource{fsys: fsys, dir: dir}
+}
+
+func (s fsSource) Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(s.fsys, s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") || strings.HasSuffix(e.Name(), ".down.sql") {
+			continue
+		}
+		raw, err := fs.ReadFile(s.fsys, path.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, err := Parse(e.Name(), string(raw))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+	return sortMigrations(migrations)
+}
+
+// Sorts by version, by name for a stable order on any filesystem, and
+// rejects duplicate versions
+func sortMigrations(migrations []Migration) ([]Migration, error) {
+	sort.Slice(migrations, func(i, j int) bool {
+		if migrations[i].Version != migrations[j].Version {
+			return migrations[i].Version < migrations[j].Version
+		}
+		return migrations[i].Name < migrations[j].Name
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %s (%s and %s)",
+				migrations[i].Version, migrations[i-1].Name, migrations[i].Name)
+		}
+	}
+	return migrations, nil
+}
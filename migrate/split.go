@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"strings"
+)
+
+const breakpointMarker = "-- statement-breakpoint"
+
+// Splits SQL into statements. Files with "-- statement-breakpoint" markers
+// (Supabase behavior) are split only at the markers. Otherwise they are split
+// at every semicolon that ends a statement, ignoring the ones in strings,
+// quoted identifiers, comments, dollar-quoted bodies, parentheses, BEGIN
+// ATOMIC function bodies and COPY ... FROM stdin data. Chunks with nothing but
+// comments and semicolons are dropped; returns nil for SQL with no statements.
+func SplitStatements(sql string) []string {
+	var ends []int      // just past each statement-ending semicolon
+	var breaks [][2]int // start and end of each marker comment
+
+	var words []string // leading words of the current statement, upper-cased
+	prev := ""         // previous word of the current statement
+	depth, atomic := 0, 0
+	copyData := false
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			if strings.HasPrefix(sql[i:i+end], breakpointMarker) {
+				breaks = append(breaks, [2]int{i, i + end})
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = SkipBlockComment(sql, i)
+		case c == '\'' || c == '"':
+			i, _ = SkipQuoted(sql, i)
+		case c == '$':
+			if tag, ok := DollarQuoteTag(sql[i:]); ok {
+				closing := strings.Index(sql[i+len(tag):], tag)
+				if closing < 0 {
+					i = len(sql)
+				} else {
+					i += len(tag) + closing + len(tag)
+				}
+				continue
+			}
+			i++
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth = max(depth-1, 0)
+			i++
+		case c == ';' && depth == 0 && atomic == 0:
+			end := i + 1
+			if copyData {
+				// The data follows up to a \. line
+				if j := strings.Index(sql[end:], "\n\\."); j >= 0 {
+					end += j + 3
+				} else {
+					end = len(sql)
+				}
+			}
+			ends = append(ends, end)
+			words, prev, copyData = nil, "", false
+			i = end
+		case IsWordByte(c):
+			end := i + 1
+			for end < len(sql) && (IsWordByte(sql[end]) || sql[end] == '$') {
+				end++
+			}
+			word := strings.ToUpper(sql[i:end])
+			if len(words) < 4 {
+				words = append(words, word)
+			}
+			if isRoutineDefinition(words) {
+				switch word {
+				case "BEGIN", "CASE":
+					atomic++
+				case "END":
+					atomic = max(atomic-1, 0)
+				}
+			}
+			if words[0] == "COPY" && prev == "FROM" && word == "STDIN" {
+				copyData = true
+			}
+			prev = word
+			i = end
+		default:
+			i++
+		}
+	}
+
+	var chunks []string
+	if len(breaks) > 0 {
+		start := 0
+		for _, b := range breaks {
+			chunks = append(chunks, sql[start:b[0]])
+			start = b[1]
+		}
+		chunks = append(chunks, sql[start:])
+	} else {
+		start := 0
+		for _, end := range ends {
+			chunks = append(chunks, sql[start:end])
+			start = end
+		}
+		chunks = append(chunks, sql[start:])
+	}
+
+	var statements []string
+	for _, c := range chunks {
+		if stmt := strings.TrimSpace(c); !onlyComments(strings.TrimRight(stmt, ";")) {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+// Reports whether a statement's leading words start a CREATE FUNCTION or
+// CREATE PROCEDURE, whose SQL-standard body (BEGIN ATOMIC ... END) holds
+// semicolons
+func isRoutineDefinition(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	kind := words[1]
+	if kind == "OR" && len(words) == 4 {
+		kind = words[3]
+	}
+	return kind == "FUNCTION" || kind == "PROCEDURE"
+}
+
+// Reports whether SQL is nothing but whitespace and comments
+func onlyComments(sql string) bool {
+	for i := 0; i < len(sql); {
+		switch c := sql[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return true
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = SkipBlockComment(sql, i)
+		default:
+			return false
+		}
+	}
+	return true
+}
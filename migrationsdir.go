@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultMigrationsDir = "./supabase/migrations"
+	supabaseConfigFile   = "./supabase/config.toml"
+)
+
+// Directory migrations are read from and created in, set from
+// resolveMigrationsDir
+var migrationsDir = defaultMigrationsDir
+
+// Picks the migrations directory: --migrations-dir, then
+// SUPABASE_MIGRATIONS_DIR, then migrations_dir under [db.migrations] in
+// supabase/config.toml (relative to the supabase directory), then
+// ./supabase/migrations
+func resolveMigrationsDir(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if dir := os.Getenv("SUPABASE_MIGRATIONS_DIR"); dir != "" {
+		return dir
+	}
+	if dir, ok := configMigrationsDir(supabaseConfigFile); ok {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(supabaseConfigFile), dir)
+		}
+		return dir
+	}
+	return defaultMigrationsDir
+}
+
+// Reads migrations_dir from the [db.migrations] table of a Supabase
+// config.toml
+func configMigrationsDir(path string) (string, bool) {
+	value, ok := configValue(path, "db.migrations", "migrations_dir")
+	if !ok {
+		return "", false
+	}
+	value, ok = unquoteTOML(value)
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// Returns the raw value of a key in a table of a Supabase config.toml. Only
+// handles the single-line `key = value` form the settings read here use
+// rather than all of TOML.
+func configValue(path, table, key string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 && !strings.ContainsAny(line[:i], `"'`) {
+			line = strings.TrimSpace(line[:i])
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(strings.Trim(line, "[]"))
+			continue
+		}
+		k, value, ok := strings.Cut(line, "=")
+		if !ok || current != table || strings.TrimSpace(k) != key {
+			continue
+		}
+		return strings.TrimSpace(value), true
+	}
+	return "", false
+}
+
+// Strips the quotes of a TOML string; bare values are returned as is
+func unquoteTOML(value string) (string, bool) {
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		end := strings.IndexByte(value[1:], value[0])
+		if end < 0 {
+			return "", false
+		}
+		value = value[1 : end+1]
+	}
+	return value, true
+}
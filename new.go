@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scaffolds a migration named {UTC timestamp}_{name}.sql, the same naming
+// the Supabase CLI uses. It starts with supabase/templates/_header.sql when
+// there is one and is otherwise empty, unless --template, --with-examples or
+// --with-down add to it. Returns the created path.
+func runNew(opts options, name string) (string, error) {
+	if name == "" && opts.template != "" && opts.templateName != "" {
+		// e.g. create_table_users
+		name = strings.ReplaceAll(opts.template, "-", "_") + "_" + opts.templateName
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid migration name %q", name)
+	}
+
+	if err := os.MkdirAll(migrationsDir, 0o755); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	version, err := nextFreeVersion(migrationsDir, now)
+	if err != nil {
+		return "", err
+	}
+	if version != now.Format(versionLayout) {
+		logWarn("Warning: version %s is already taken, using %s instead", now.Format(versionLayout), version)
+	}
+
+	filename := version + "_" + name + ".sql"
+	if err := validateMigrationFilenames([]string{filename}); err != nil {
+		return "", err
+	}
+
+	var body string
+	data := templateData{
+		Name:        opts.templateName,
+		Schema:      opts.templateSchema,
+		Version:     version,
+		OwnerColumn: opts.ownerColumn,
+	}
+	if opts.template != "" {
+		if opts.templateName == "" {
+			return "", fmt.Errorf("--template requires --name")
+		}
+		if body, err = renderTemplate(opts.template, data); err != nil {
+			return "", err
+		}
+	}
+	if opts.withUpdatedAt {
+		if opts.templateName == "" {
+			return "", fmt.Errorf("--with-updated-at requires --name")
+		}
+		trigger, err := renderTemplate("updated-at", data)
+		if err != nil {
+			return "", err
+		}
+		if body != "" {
+			body += "\n"
+		}
+		body += trigger
+	}
+	if opts.withExamples && body == "" {
+		body = breakpointExample
+	}
+	header, err := renderHeader(data)
+	if err != nil {
+		return "", err
+	}
+	if header != "" {
+		body = strings.TrimRight(header, "\n") + "\n\n" + body
+	}
+	if opts.withDown {
+		if body != "" {
+			body = strings.TrimRight(body, "\n") + "\n\n"
+		}
+		body += downSectionStub
+	}
+
+	path := filepath.Join(migrationsDir, filename)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(body); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Sets up `new policy TABLE` as the policy-PATTERN template for the table,
+// accepting schema-qualified table names
+func policyTemplateOptions(opts options, table string) (options, error) {
+	valid := false
+	for _, p := range policyPatterns {
+		valid = valid || p == opts.policy
+	}
+	if !valid {
+		return opts, fmt.Errorf("unknown policy pattern %q (available: %s)", opts.policy, strings.Join(policyPatterns, ", "))
+	}
+	opts.template = "policy-" + opts.policy
+	opts.templateName = table
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		opts.templateSchema, opts.templateName = schema, name
+	}
+	return opts, nil
+}
+
+// Returns the version for t, bumped one second at a time while another
+// migration already uses it. Two developers scaffolding in the same second
+// would otherwise produce duplicate versions that only collide after merging.
+func nextFreeVersion(dir string, t time.Time) (string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	taken := map[string]bool{}
+	for _, f := range files {
+		if version, _, ok := strings.Cut(f.Name(), "_"); ok {
+			taken[version] = true
+		}
+	}
+
+	for {
+		version := t.Format(versionLayout)
+		if !taken[version] {
+			return version, nil
+		}
+		t = t.Add(time.Second)
+	}
+}
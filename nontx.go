@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statements PostgreSQL refuses to run inside a transaction block, by
+// leading keywords
+var nonTransactionalPrefixes = []string{
+	"CREATE INDEX CONCURRENTLY",
+	"CREATE UNIQUE INDEX CONCURRENTLY",
+	"DROP INDEX CONCURRENTLY",
+	"REINDEX INDEX CONCURRENTLY",
+	"REINDEX TABLE CONCURRENTLY",
+	"REINDEX SCHEMA",
+	"REINDEX DATABASE",
+	"REINDEX SYSTEM",
+	"VACUUM",
+	"ALTER SYSTEM",
+	"CREATE DATABASE",
+	"DROP DATABASE",
+	"CREATE TABLESPACE",
+	"DROP TABLESPACE",
+	"ALTER SUBSCRIPTION",
+	"CREATE SUBSCRIPTION",
+	"DROP SUBSCRIPTION",
+}
+
+// Returns the offending command if any command in stmt cannot run inside a
+// transaction block, or "" if all can
+func nonTransactionalCommand(stmt string) string {
+	for _, cmd := range splitCommands(stmt) {
+		for _, prefix := range nonTransactionalPrefixes {
+			if cmd.is(0, strings.Fields(prefix)...) {
+				return prefix
+			}
+		}
+	}
+	return ""
+}
+
+// Finds statements in transactional migrations that PostgreSQL would reject
+// with "cannot run inside a transaction block", so the plan fails before
+// anything runs instead of rolling back halfway through the deploy
+func checkTransactionSafety(migrations []Migration) error {
+	var problems []string
+	for _, m := range migrations {
+		if m.Directives.NoTransaction {
+			continue
+		}
+		for i, stmt := range m.Statements {
+			if cmd := nonTransactionalCommand(stmt); cmd != "" {
+				problems = append(problems, fmt.Sprintf(
+					"%s (%s), statement %d: %s cannot run inside a transaction block; add `-- no-transaction` to the migration to run its statements outside one",
+					m.Version, m.Name, i+1, cmd))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("pending migrations contain non-transactional statements:\n  - %s", strings.Join(problems, "\n  - "))
+}
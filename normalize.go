@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Set by --normalize-hash: migrations are hashed after normalizeSQL, so
+// cosmetic edits don't show up as changed applied migrations
+var normalizeHashes bool
+
+// Returns the hash recorded for a migration's contents
+func migrationHash(raw string) string {
+	if normalizeHashes {
+		raw = normalizeSQL(raw)
+	}
+	return migrate.Hash(raw)
+}
+
+// Canonicalizes formatting that doesn't change what a migration does: line
+// endings, trailing whitespace, blank lines at the start and end, and
+// repeated semicolons at the end of a line
+func normalizeSQL(raw string) string {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	raw = strings.ReplaceAll(raw, "\r", "\n")
+	lines := strings.Split(raw, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if strings.HasSuffix(line, ";") {
+			line = strings.TrimRight(line, "; \t") + ";"
+		}
+		lines[i] = line
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
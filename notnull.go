@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// A column a statement makes NOT NULL
+type notNullColumn struct {
+	Table  string // as written
+	Column string // as written
+}
+
+// Returns the column of a single ALTER TABLE ... ALTER [COLUMN] c SET NOT NULL
+func setNotNullOf(stmt string) (notNullColumn, bool) {
+	commands := splitCommands(stmt)
+	if len(commands) != 1 {
+		return notNullColumn{}, false
+	}
+	cmd := commands[0]
+	if !cmd.is(0, "ALTER", "TABLE") {
+		return notNullColumn{}, false
+	}
+	table, i := readQualifiedName(cmd, cmd.skip(2, "IF", "EXISTS", "ONLY"))
+	if !cmd.is(i, "ALTER") {
+		return notNullColumn{}, false
+	}
+	i = cmd.skip(i+1, "COLUMN")
+	if i+4 != len(cmd) || !cmd.is(i+1, "SET", "NOT", "NULL") {
+		return notNullColumn{}, false
+	}
+	return notNullColumn{Table: table, Column: cmd[i]}, true
+}
+
+// Rewrites SET NOT NULL into the PostgreSQL 12+ pattern that avoids scanning
+// the table under an ACCESS EXCLUSIVE lock: add a NOT VALID check constraint
+// now, and after commit validate it (without blocking reads or writes), set
+// NOT NULL (which trusts the valid constraint instead of scanning) and drop
+// the constraint again
+func splitSetNotNull(stmt string) (string, []string, bool) {
+	c, ok := setNotNullOf(stmt)
+	if !ok {
+		return "", nil, false
+	}
+
+	parts := strings.Split(c.Table, ".")
+	name := unquoteIdent(parts[len(parts)-1]) + "_" + unquoteIdent(c.Column) + "_not_null"
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	constraint := quoteIdentIfNeeded(name)
+
+	add := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s CHECK (%s IS NOT NULL) NOT VALID", c.Table, constraint, c.Column)
+	after := []string{
+		fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", c.Table, constraint),
+		fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", c.Table, c.Column),
+		fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", c.Table, constraint),
+	}
+	return add, after, true
+}
+
+// Reports whether the table is big enough for the safe NOT NULL pattern to be
+// worth it. Tables that don't exist yet are created by the migration and are
+// empty.
+func largeEnoughForSafeNotNull(ctx context.Context, db *sql.DB, table string, minRows int64) bool {
+	if minRows <= 0 {
+		return true
+	}
+	rows, _, ok, err := relationStats(ctx, db, table)
+	if err != nil {
+		logWarn("Warning: could not read statistics for %s: %v", table, err)
+		return true
+	}
+	return ok && rows >= minRows
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Finds pending migrations dated before the newest applied one, usually
+// written on a branch that merged after a newer migration was deployed.
+// Fails unless allow is set, in which case it warns and returns version ->
+// newest applied version, recorded in out_of_order_after.
+func checkOrder(pending []Migration, applied map[string]string, allow bool) (map[string]string, error) {
+	latest := latestVersion(applied)
+	var late []string
+	outOfOrder := map[string]string{}
+	for _, m := range pending {
+		if latest != "" && m.Version < latest {
+			late = append(late, fmt.Sprintf("%s (%s)", m.Version, m.Name))
+			outOfOrder[m.Version] = latest
+		}
+	}
+	if len(late) == 0 {
+		return nil, nil
+	}
+	if !allow {
+		return nil, fmt.Errorf("pending migrations are older than the newest applied one, %s:\n  - %s\nRe-timestamp them with `rebase`, or pass --allow-out-of-order to apply them anyway",
+			latest, strings.Join(late, "\n  - "))
+	}
+	for _, m := range late {
+		logWarn("Warning: applying %s out of order, after %s", m, latest)
+	}
+	return outOfOrder, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const ownershipTriggerName = "supabase_migrate_capture_created"
+
+// ALTER ... OWNER TO command for each object type reported by
+// pg_event_trigger_ddl_commands(). Indexes, columns, policies and triggers
+// belong to their table and are left alone.
+var ownerAlterCommands = map[string]string{
+	"table":             "ALTER TABLE",
+	"view":              "ALTER VIEW",
+	"materialized view": "ALTER MATERIALIZED VIEW",
+	"foreign table":     "ALTER FOREIGN TABLE",
+	"sequence":          "ALTER SEQUENCE",
+	"function":          "ALTER FUNCTION",
+	"procedure":         "ALTER PROCEDURE",
+	"aggregate":         "ALTER AGGREGATE",
+	"type":              "ALTER TYPE",
+	"domain":            "ALTER DOMAIN",
+	"schema":            "ALTER SCHEMA",
+}
+
+// Installs the event trigger that records objects created by sessions that
+// opt in with supabase_migrate.capture = on, into a temporary table of the
+// session. Other sessions are unaffected, so the trigger can stay installed.
+// Creating event triggers needs a superuser (or supautils on Supabase).
+func ensureOwnershipCapture(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE OR REPLACE FUNCTION %s.capture_created_objects() RETURNS event_trigger
+		LANGUAGE plpgsql AS $$
+		DECLARE
+			obj record;
+		BEGIN
+			IF current_setting('supabase_migrate.capture', true) IS DISTINCT FROM 'on' THEN
+				RETURN;
+			END IF;
+			FOR obj IN SELECT * FROM pg_event_trigger_ddl_commands() WHERE command_tag LIKE 'CREATE %%' LOOP
+				INSERT INTO pg_temp.supabase_migrate_created (object_type, object_identity, objid)
+				VALUES (obj.object_type, obj.object_identity, obj.objid);
+			END LOOP;
+		END
+		$$
+	`, schemaName))
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM pg_event_trigger WHERE evtname = $1)`, ownershipTriggerName).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE EVENT TRIGGER %s ON ddl_command_end
+		EXECUTE FUNCTION %s.capture_created_objects()
+	`, ownershipTriggerName, schemaName))
+	return err
+}
+
+// Starts recording the objects this connection creates
+func startOwnershipCapture(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TEMP TABLE IF NOT EXISTS supabase_migrate_created (
+			object_type TEXT NOT NULL,
+			object_identity TEXT NOT NULL,
+			objid OID
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `SET supabase_migrate.capture = 'on'`)
+	return err
+}
+
+// Hands the objects recorded since the capture started to role. Sequences
+// owned by a column follow their table and are skipped, and so are objects
+// the migration dropped again after creating them: their objid is gone from
+// the catalog (a re-created object has a new objid and is recorded again).
+func transferOwnership(ctx context.Context, ex execer, role string) error {
+	rows, err := ex.QueryContext(ctx, `
+		SELECT DISTINCT c.object_type, c.object_identity
+		FROM pg_temp.supabase_migrate_created c
+		WHERE NOT (c.object_type = 'sequence' AND EXISTS(
+			SELECT 1 FROM pg_depend d
+			WHERE d.classid = 'pg_class'::regclass AND d.objid = c.objid AND d.deptype IN ('a', 'i')
+		))
+		AND CASE
+			WHEN c.object_type IN ('table', 'view', 'materialized view', 'foreign table', 'sequence')
+				THEN EXISTS(SELECT 1 FROM pg_class WHERE oid = c.objid)
+			WHEN c.object_type IN ('function', 'procedure', 'aggregate')
+				THEN EXISTS(SELECT 1 FROM pg_proc WHERE oid = c.objid)
+			WHEN c.object_type IN ('type', 'domain')
+				THEN EXISTS(SELECT 1 FROM pg_type WHERE oid = c.objid)
+			WHEN c.object_type = 'schema'
+				THEN EXISTS(SELECT 1 FROM pg_namespace WHERE oid = c.objid)
+			ELSE true
+		END
+	`)
+	if err != nil {
+		return err
+	}
+	type object struct{ kind, identity string }
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.kind, &o.identity); err != nil {
+			rows.Close()
+			return err
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	owner := pgx.Identifier{unquoteIdent(role)}.Sanitize()
+	for _, o := range objects {
+		alter, ok := ownerAlterCommands[o.kind]
+		if !ok {
+			continue
+		}
+		// object_identity is already quoted as needed
+		if _, err := ex.ExecContext(ctx, fmt.Sprintf(`%s %s OWNER TO %s`, alter, o.identity, owner)); err != nil {
+			return fmt.Errorf("transferring %s %s to %s: %v", o.kind, o.identity, role, err)
+		}
+		logDebug("Transferred %s %s to %s.", o.kind, o.identity, role)
+	}
+	if len(objects) > 0 {
+		logInfo("Transferred ownership of %d new object(s) to %s.", len(objects), role)
+	}
+
+	_, err = ex.ExecContext(ctx, `TRUNCATE pg_temp.supabase_migrate_created`)
+	return err
+}
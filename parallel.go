@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Returns, for each statement, the number of the -- parallel ... -- end-parallel
+// block it belongs to (counting from 1), or 0 outside blocks. A statement
+// belongs to a block when its first line of SQL comes after a -- parallel
+// line with no -- end-parallel in between.
+func parallelBlocks(statements []string) []int {
+	blocks := make([]int, len(statements))
+	block, inBlock := 0, false
+	for i, stmt := range statements {
+		seenSQL := false
+		for _, line := range strings.Split(stmt, "\n") {
+			line = strings.TrimSpace(line)
+			if match := directiveLine.FindStringSubmatch(line); match != nil {
+				switch match[1] {
+				case "parallel":
+					block++
+					inBlock = true
+				case "end-parallel":
+					inBlock = false
+				}
+				continue
+			}
+			if line == "" || strings.HasPrefix(line, "--") || seenSQL {
+				continue
+			}
+			seenSQL = true
+			if inBlock {
+				blocks[i] = block
+			}
+		}
+	}
+	return blocks
+}
+
+// Runs the statements of a -- parallel block concurrently, each on its own
+// connection, at most --statement-concurrency at a time. The first failure
+// cancels the statements still running; the others are left as they are,
+// like any statement of a -- no-transaction migration.
+func (r *applyRun) execParallel(ctx context.Context, m Migration, pid, first int, statements []string, policy *lockRetryPolicy) error {
+	concurrency := max(1, r.opts.statementConcurrency)
+	logInfo("Running statements %d-%d of %s in parallel (up to %d at a time)", first+1, first+len(statements), m.Version, concurrency)
+
+	stopHeartbeat := startHeartbeat(ctx, r.lock, m.Version, pid, r.opts.heartbeatInterval)
+	defer stopHeartbeat()
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for k, stmt := range statements {
+		n := first + k + 1
+		if r.alreadyRan(n-1, stmt) {
+			logInfo("Statement %d of %s completed in an earlier attempt; skipping it.", n, m.Version)
+			continue
+		}
+		g.Go(func() error {
+			conn, err := r.db.Conn(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			reset, err := r.prepareConn(ctx, conn, m, policy)
+			defer reset()
+			if err != nil {
+				return err
+			}
+
+			var pid int
+			if err := conn.QueryRowContext(ctx, `SELECT pg_backend_pid()`).Scan(&pid); err != nil {
+				return err
+			}
+			if r.opts.blockerReport {
+				if err := reportBlockers(ctx, r.db, pid, stmt, r.opts.maxBlockers); err != nil {
+					return err
+				}
+			}
+
+			logDebug("Executing statement %d/%d of %s (backend pid %d):\n%s", n, len(m.Statements), m.Version, pid, stmt)
+			started := time.Now()
+			stopTerminator := startBlockerTerminator(ctx, r.db, r.opts.terminateBlockers, m.Version, pid)
+			err = execWithLockRetry(ctx, conn, false, stmt, policy)
+			stopTerminator()
+			if err != nil {
+				logEvent(levelDebug, "statement_failed", logFields{"version": m.Version, "statement": n, "error": err.Error(), "duration_ms": time.Since(started).Milliseconds()}, "Statement %d/%d of %s failed after %s", n, len(m.Statements), m.Version, time.Since(started))
+				return &statementError{index: n - 1, sql: stmt, err: err}
+			}
+			r.noteStatement(m.Version, n, time.Since(started))
+			if !m.Directives.RunAlways {
+				// Even when a sibling failed, this one did complete
+				if err := recordStatementProgress(context.WithoutCancel(ctx), r.db, m.Version, n-1, stmt); err != nil {
+					return err
+				}
+			}
+			logEvent(levelDebug, "statement_executed", logFields{"version": m.Version, "statement": n, "duration_ms": time.Since(started).Milliseconds()}, "Statement %d/%d of %s finished in %s", n, len(m.Statements), m.Version, time.Since(started))
+
+			// Objects are captured per session, so each connection hands over its own
+			if r.opts.ownerRole != "" {
+				if m.Directives.RunAs != "" {
+					if _, err := conn.ExecContext(ctx, `RESET ROLE`); err != nil {
+						return err
+					}
+				}
+				if err := transferOwnership(ctx, conn, r.opts.ownerRole); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("parallel block of %s: %v", m.Version, err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Time-based partitions to keep created ahead for a table partitioned by
+// range, from a -- partitions directive
+type partitionSpec struct {
+	Table   string
+	Period  string // daily, weekly, monthly or yearly
+	Premake int    // partitions to keep ahead of the current one
+}
+
+// Partitions kept ahead when the directive doesn't say
+const defaultPremake = 3
+
+// Tables with a -- partitions directive; `partitions ensure` keeps their
+// partitions created ahead
+const partitionsTableName = "schema_migrations_partitions"
+
+// Parses `TABLE PERIOD [PREMAKE]`, e.g. public.events monthly 6
+func parsePartitionsDirective(value string) (partitionSpec, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || len(fields) > 3 {
+		return partitionSpec{}, fmt.Errorf("expected TABLE daily|weekly|monthly|yearly [PREMAKE], got %q", value)
+	}
+	spec := partitionSpec{Table: fields[0], Period: strings.ToLower(fields[1]), Premake: defaultPremake}
+	switch spec.Period {
+	case "daily", "weekly", "monthly", "yearly":
+	default:
+		return spec, fmt.Errorf("unknown period %q: use daily, weekly, monthly or yearly", fields[1])
+	}
+	if len(fields) == 3 {
+		n, err := strconv.Atoi(fields[2])
+		if err != nil || n < 0 {
+			return spec, fmt.Errorf("invalid number of partitions to create ahead: %q", fields[2])
+		}
+		spec.Premake = n
+	}
+	return spec, nil
+}
+
+// Returns the start of the period t falls in, in UTC. Weeks start on Monday.
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	switch period {
+	case "daily":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case "weekly":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "monthly":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Returns the start of the period n periods after the one starting at start
+func addPeriods(period string, start time.Time, n int) time.Time {
+	switch period {
+	case "daily":
+		return start.AddDate(0, 0, n)
+	case "weekly":
+		return start.AddDate(0, 0, 7*n)
+	case "monthly":
+		return start.AddDate(0, n, 0)
+	default:
+		return start.AddDate(n, 0, 0)
+	}
+}
+
+// Returns the suffix naming the partition of the period starting at start,
+// e.g. p20240115 for a day or week, p202401 for a month and p2024 for a year
+func partitionSuffix(period string, start time.Time) string {
+	switch period {
+	case "daily", "weekly":
+		return "p" + start.Format("20060102")
+	case "monthly":
+		return "p" + start.Format("200601")
+	default:
+		return "p" + start.Format("2006")
+	}
+}
+
+// Creates the partitions of the current period and the Premake after it that
+// don't exist yet, named TABLE_pSUFFIX next to the table. The table must be
+// partitioned by range on a single date or timestamp column. Returns the
+// partitions it created.
+func ensurePartitions(ctx context.Context, ex execer, spec partitionSpec, now time.Time) ([]string, error) {
+	var schema, table string
+	var strategy string
+	var keys int
+	err := ex.QueryRowContext(ctx, `
+		SELECT n.nspname, c.relname, p.partstrat::text, p.partnatts
+		FROM pg_partitioned_table p
+		JOIN pg_class c ON c.oid = p.partrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE p.partrelid = to_regclass($1)
+	`, spec.Table).Scan(&schema, &table, &strategy, &keys)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%s is not a partitioned table", spec.Table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strategy != "r" || keys != 1 {
+		return nil, fmt.Errorf("%s must be partitioned by range on a single column", spec.Table)
+	}
+
+	var created []string
+	start := periodStart(spec.Period, now)
+	for i := 0; i <= spec.Premake; i++ {
+		from, to := addPeriods(spec.Period, start, i), addPeriods(spec.Period, start, i+1)
+		name := pgx.Identifier{schema, table + "_" + partitionSuffix(spec.Period, from)}.Sanitize()
+		var exists bool
+		if err := ex.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+			name, pgx.Identifier{schema, table}.Sanitize(), from.Format("2006-01-02"), to.Format("2006-01-02"))
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("creating partition %s: %v", name, err)
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+func ensurePartitionsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			table_name TEXT PRIMARY KEY,
+			period TEXT NOT NULL,
+			premake INT NOT NULL,
+			version TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`, schemaName, partitionsTableName))
+	return err
+}
+
+// Records a migration's partitioned tables, in the migration's transaction,
+// for `partitions ensure`. A later directive for the same table replaces the
+// earlier one.
+func recordPartitionSpecs(ctx context.Context, ex execer, version string, specs []partitionSpec) error {
+	for _, p := range specs {
+		_, err := ex.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s.%s (table_name, period, premake, version) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (table_name) DO UPDATE SET
+				period = EXCLUDED.period, premake = EXCLUDED.premake, version = EXCLUDED.version, updated_at = NOW()
+		`, schemaName, partitionsTableName), p.Table, p.Period, p.Premake, version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handles `partitions ensure`: creates the partitions due for every table a
+// migration declared with -- partitions. Meant to run on a schedule, at least
+// once per period; tables that were dropped since are skipped.
+func runPartitions(dbURL string, args []string) error {
+	if len(args) != 1 || args[0] != "ensure" {
+		return fmt.Errorf("partitions requires ensure")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ensurePartitionsTable(ctx, db); err != nil {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT table_name, period, premake, to_regclass(table_name) IS NOT NULL
+		FROM %s.%s ORDER BY table_name
+	`, schemaName, partitionsTableName))
+	if err != nil {
+		return err
+	}
+	type declared struct {
+		spec   partitionSpec
+		exists bool
+	}
+	var tables []declared
+	for rows.Next() {
+		var d declared
+		if err := rows.Scan(&d.spec.Table, &d.spec.Period, &d.spec.Premake, &d.exists); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	total := 0
+	for _, d := range tables {
+		if !d.exists {
+			logWarn("Warning: %s no longer exists; skipping it", d.spec.Table)
+			continue
+		}
+		created, err := ensurePartitions(ctx, db, d.spec, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, name := range created {
+			logInfo("Created partition %s.", name)
+		}
+		total += len(created)
+	}
+	logInfo("Checked %d partitioned table(s); created %d partition(s).", len(tables), total)
+	return nil
+}
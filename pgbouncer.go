@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Reports whether any statement of a migration takes an ACCESS EXCLUSIVE
+// lock, which queues every other query on the table behind it
+func needsExclusiveLock(m Migration) bool {
+	for _, stmt := range m.Statements {
+		for _, target := range lockTargets(stmt) {
+			if target.Mode == "AccessExclusiveLock" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Issues PAUSE on the PgBouncer admin console so in-flight application
+// transactions finish and new ones wait in PgBouncer instead of queuing behind
+// the migration's locks. Returns a function that issues RESUME; it must be
+// called even if the migration fails. database limits the pause to one
+// PgBouncer database; empty pauses all of them.
+//
+// The migration itself must not connect through the paused PgBouncer.
+func pausePgBouncer(ctx context.Context, adminURL, database string, timeout time.Duration) (func(), error) {
+	// The admin console only speaks the simple query protocol
+	config, err := pgx.ParseConfig(adminURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PgBouncer admin URL: %v", err)
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the PgBouncer admin console: %v", err)
+	}
+
+	pause, resume := "PAUSE", "RESUME"
+	if database != "" {
+		pause += " " + database
+		resume += " " + database
+	}
+
+	// PAUSE returns once every server connection is released, which a
+	// long-running application transaction can hold up indefinitely
+	pauseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	logInfo("Pausing PgBouncer (%s)...", pause)
+	started := time.Now()
+	if _, err := conn.PgConn().Exec(pauseCtx, pause).ReadAll(); err != nil {
+		// A timed out PAUSE may still be in effect
+		conn.Close(ctx)
+		if conn, cerr := pgx.ConnectConfig(ctx, config); cerr == nil {
+			conn.PgConn().Exec(ctx, resume).ReadAll()
+			conn.Close(ctx)
+		}
+		return nil, fmt.Errorf("PgBouncer %s failed after %s: %v", pause, time.Since(started).Round(time.Millisecond), err)
+	}
+	logInfo("PgBouncer paused after %s.", time.Since(started).Round(time.Millisecond))
+
+	return func() {
+		if _, err := conn.PgConn().Exec(ctx, resume).ReadAll(); err != nil {
+			logError("Error: PgBouncer %s failed, clients stay paused until it is resumed by hand: %v", resume, err)
+		} else {
+			logInfo("PgBouncer resumed.")
+		}
+		conn.Close(ctx)
+	}, nil
+}
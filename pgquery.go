@@ -0,0 +1,43 @@
+//go:build pg_query
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/pganalyze/pg_query_go/v6/parser"
+)
+
+// validate checks the full PostgreSQL grammar, and commands are split by it
+const validateParser = "libpg_query"
+
+// Parses SQL with PostgreSQL's own parser (libpg_query, through cgo) and
+// returns its syntax error, if any, with the line it points at
+func parserSyntaxProblems(sql string) []string {
+	_, err := pg_query.Parse(sql)
+	if err == nil {
+		return nil
+	}
+	var perr *parser.Error
+	if errors.As(err, &perr) && perr.Cursorpos > 0 {
+		// Cursorpos counts characters from 1
+		runes := []rune(sql)
+		pos := min(perr.Cursorpos-1, len(runes))
+		line := strings.Count(string(runes[:pos]), "\n") + 1
+		return []string{fmt.Sprintf("line %d: %s", line, perr.Message)}
+	}
+	return []string{err.Error()}
+}
+
+// Splits SQL into the text of each command with PostgreSQL's parser, so
+// command boundaries are exactly the server's. Fails on a syntax error.
+func parserSplitCommands(sql string) ([]string, bool) {
+	commands, err := pg_query.SplitWithParser(sql, true)
+	if err != nil {
+		return nil, false
+	}
+	return commands, true
+}
@@ -0,0 +1,15 @@
+//go:build !pg_query
+
+package main
+
+// validate only runs the lexical pass and commands are split by the lexer;
+// build with -tags pg_query to use PostgreSQL's parser for both
+const validateParser = ""
+
+func parserSyntaxProblems(sql string) []string {
+	return nil
+}
+
+func parserSplitCommands(sql string) ([]string, bool) {
+	return nil, false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+const defaultTestsDir = "./supabase/tests"
+
+var (
+	tapPlanRe   = regexp.MustCompile(`^1\.\.(\d+)`)
+	tapResultRe = regexp.MustCompile(`^(not )?ok\b(.*)$`)
+)
+
+// Outcome of one pgTAP test file
+type tapResult struct {
+	Planned int
+	Passed  int
+	Failed  int
+	Todo    int
+	Err     error
+}
+
+func (r tapResult) ok() bool {
+	return r.Err == nil && r.Failed == 0 && (r.Planned == 0 || r.Planned == r.Passed+r.Failed+r.Todo)
+}
+
+// Runs every .sql file in dir against the database as a pgTAP test, printing
+// the TAP output as it goes. pgTAP is created (in --extension-schema, if set)
+// when it isn't installed. Returns false if any test failed or errored.
+func runPgTAP(opts options, dbURL string) (bool, error) {
+	files, err := filepath.Glob(filepath.Join(opts.pgtapDir, "*.sql"))
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		return false, fmt.Errorf("no test files found in %s", opts.pgtapDir)
+	}
+	sort.Strings(files)
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	if err := ensurePgTAP(ctx, db, opts.extensionSchema); err != nil {
+		return false, err
+	}
+
+	failedFiles := 0
+	for _, path := range files {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+
+		logInfo("# %s", filepath.Base(path))
+		res := runTAPFile(ctx, db, string(raw))
+		switch {
+		case res.Err != nil:
+			logError("Error in %s: %v", filepath.Base(path), res.Err)
+		case res.Planned != 0 && res.Planned != res.Passed+res.Failed+res.Todo:
+			logError("%s: planned %d tests but ran %d", filepath.Base(path), res.Planned, res.Passed+res.Failed+res.Todo)
+		}
+		if !res.ok() {
+			failedFiles++
+		}
+	}
+
+	if failedFiles > 0 {
+		logError("%d of %d test files failed.", failedFiles, len(files))
+		return false, nil
+	}
+	logInfo("All %d test files passed.", len(files))
+	return true, nil
+}
+
+func ensurePgTAP(ctx context.Context, db *sql.DB, schema string) error {
+	var installed bool
+	if err := db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pgtap')`).Scan(&installed); err != nil {
+		return err
+	}
+	if installed {
+		return nil
+	}
+
+	stmt := `CREATE EXTENSION IF NOT EXISTS pgtap`
+	if schema != "" {
+		stmt += fmt.Sprintf(` WITH SCHEMA %s`, pgx.Identifier{schema}.Sanitize())
+	}
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("could not create the pgtap extension: %v", err)
+	}
+	logInfo("Created extension pgtap.")
+	return nil
+}
+
+// Sends a test file as a single simple-protocol query, so it can hold several
+// statements (begin, plan, assertions, finish, rollback) like it would under
+// psql, and tallies the TAP lines the assertions return. A transaction the
+// file leaves open is rolled back so tests never persist changes.
+func runTAPFile(ctx context.Context, db *sql.DB, script string) tapResult {
+	var res tapResult
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn any) error {
+		pg := driverConn.(*stdlib.Conn).Conn().PgConn()
+		results, err := pg.Exec(ctx, script).ReadAll()
+		for _, r := range results {
+			for _, row := range r.Rows {
+				for _, col := range row {
+					for _, line := range strings.Split(string(col), "\n") {
+						res.record(line)
+					}
+				}
+			}
+		}
+		if pg.TxStatus() != 'I' {
+			if _, rbErr := pg.Exec(ctx, "ROLLBACK").ReadAll(); rbErr != nil && err == nil {
+				err = rbErr
+			}
+		}
+		return err
+	})
+	res.Err = err
+	return res
+}
+
+// Prints a TAP line and counts it
+func (r *tapResult) record(line string) {
+	if line == "" {
+		return
+	}
+	if m := tapPlanRe.FindStringSubmatch(line); m != nil {
+		r.Planned, _ = strconv.Atoi(m[1])
+		logInfo("%s", line)
+		return
+	}
+	m := tapResultRe.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		logInfo("%s", line)
+	case strings.Contains(strings.ToUpper(m[2]), "# TODO"):
+		r.Todo++
+		logInfo("%s", line)
+	case m[1] == "":
+		r.Passed++
+		logInfo("%s", line)
+	default:
+		r.Failed++
+		logError("%s", line)
+	}
+}
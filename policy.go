@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Checks the version timestamp policy for migrations that haven't been
+// applied yet: versions are UTC timestamps with second precision
+// (YYYYMMDDHHMMSS), not ahead of the current UTC time (a sign of a local-time
+// timestamp), and strictly newer than latestApplied when it is known.
+// Returns one message per violation.
+func checkVersionPolicy(migrations []Migration, latestApplied string, now time.Time) []string {
+	var problems []string
+	for _, m := range migrations {
+		t, ok := parseVersionTime(m.Version)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s_%s: version must be a UTC timestamp with second precision (%s)",
+				m.Version, m.Name, "YYYYMMDDHHMMSS"))
+			continue
+		}
+		if t.After(now) {
+			problems = append(problems, fmt.Sprintf("%s_%s: version is ahead of the current UTC time %s; was it generated in local time?",
+				m.Version, m.Name, now.UTC().Format(versionLayout)))
+		}
+		if latestApplied != "" && m.Version <= latestApplied {
+			problems = append(problems, fmt.Sprintf("%s_%s: version must be newer than the latest applied migration %s",
+				m.Version, m.Name, latestApplied))
+		}
+	}
+	return problems
+}
+
+// Lints every local migration offline. Names that rely on search_path are
+// warnings unless --strict. Returns false if any check failed.
+func runLint(opts options) bool {
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+
+	problems := checkVersionPolicy(migrations, "", time.Now())
+	for _, p := range problems {
+		logError("%s", p)
+	}
+
+	// Migrations applied long ago can't be fixed anymore; --since skips them
+	var recent []Migration
+	for _, m := range migrations {
+		if opts.since == "" || m.Version >= opts.since {
+			recent = append(recent, m)
+		}
+	}
+	for _, p := range checkQualifiedNames(recent, opts.templateSchema) {
+		if opts.strict {
+			logError("%s", p)
+			problems = append(problems, p)
+		} else {
+			logWarn("Warning: %s", p)
+		}
+	}
+	if len(problems) > 0 {
+		logError("%d problem(s) found in %d migrations.", len(problems), len(migrations))
+		return false
+	}
+
+	logInfo("%d migrations passed lint.", len(migrations))
+	return true
+}
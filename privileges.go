@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// A privilege a statement needs from the connecting role
+type privilegeRequirement struct {
+	Kind   string // "create-in-schema", "create-in-database", "owner" or "member"
+	Object string // schema, relation or role name as written
+}
+
+func (p privilegeRequirement) String() string {
+	switch p.Kind {
+	case "create-in-schema":
+		if p.Object == "" {
+			return "CREATE on the current schema"
+		}
+		return fmt.Sprintf("CREATE on schema %s", p.Object)
+	case "create-in-database":
+		return "CREATE on the current database"
+	case "owner":
+		return fmt.Sprintf("ownership of %s", p.Object)
+	default:
+		return fmt.Sprintf("membership in role %s", p.Object)
+	}
+}
+
+// Object kinds whose creation needs CREATE on the containing schema
+var schemaObjectKinds = map[string]bool{
+	"TABLE": true, "VIEW": true, "FUNCTION": true, "PROCEDURE": true,
+	"TYPE": true, "SEQUENCE": true, "DOMAIN": true, "AGGREGATE": true,
+}
+
+// Returns the privileges a statement needs, by command class: creating
+// objects needs CREATE on their schema, altering tables needs ownership, and
+// handing objects to or switching to another role needs membership in it.
+func privilegeRequirements(stmt string) []privilegeRequirement {
+	var reqs []privilegeRequirement
+	for _, cmd := range splitCommands(stmt) {
+		reqs = append(reqs, commandPrivilegeRequirements(cmd)...)
+	}
+	return reqs
+}
+
+func commandPrivilegeRequirements(tokens sqlCommand) []privilegeRequirement {
+
+	var reqs []privilegeRequirement
+
+	if isKeyword(tokens[0], "CREATE") {
+		i := 1
+		for i < len(tokens) && (isKeyword(tokens[i], "OR") || isKeyword(tokens[i], "REPLACE") ||
+			isKeyword(tokens[i], "UNLOGGED") || isKeyword(tokens[i], "MATERIALIZED") || isKeyword(tokens[i], "RECURSIVE")) {
+			i++
+		}
+		switch {
+		case i < len(tokens) && isKeyword(tokens[i], "SCHEMA"):
+			reqs = append(reqs, privilegeRequirement{Kind: "create-in-database"})
+		case i < len(tokens) && schemaObjectKinds[strings.ToUpper(tokens[i])]:
+			i++
+			for i < len(tokens) && (isKeyword(tokens[i], "IF") || isKeyword(tokens[i], "NOT") || isKeyword(tokens[i], "EXISTS")) {
+				i++
+			}
+			name, _ := readQualifiedName(tokens, i)
+			if schema, _, ok := strings.Cut(name, "."); ok {
+				reqs = append(reqs, privilegeRequirement{Kind: "create-in-schema", Object: schema})
+			} else if name != "" {
+				reqs = append(reqs, privilegeRequirement{Kind: "create-in-schema", Object: ""})
+			}
+		}
+	}
+
+	// Table-level DDL needs ownership of the table. LOCK and TRUNCATE only
+	// need table privileges, and tables referenced by a new foreign key (the
+	// targets after the first in ALTER TABLE) only need REFERENCES.
+	if !isKeyword(tokens[0], "LOCK") && !isKeyword(tokens[0], "TRUNCATE") {
+		for i, target := range commandLockTargets(tokens) {
+			if i > 0 && isKeyword(tokens[0], "ALTER") {
+				break
+			}
+			reqs = append(reqs, privilegeRequirement{Kind: "owner", Object: target.Relation})
+		}
+	}
+	if isKeyword(tokens[0], "CREATE") || isKeyword(tokens[0], "ALTER") || isKeyword(tokens[0], "DROP") {
+		for j := 0; j+3 < len(tokens); j++ {
+			if isKeyword(tokens[j], "POLICY") && isKeyword(tokens[j+2], "ON") {
+				name, _ := readQualifiedName(tokens, j+3)
+				reqs = append(reqs, privilegeRequirement{Kind: "owner", Object: name})
+			}
+		}
+	}
+
+	for j := 0; j+2 < len(tokens); j++ {
+		if isKeyword(tokens[j], "OWNER") && isKeyword(tokens[j+1], "TO") {
+			reqs = append(reqs, privilegeRequirement{Kind: "member", Object: tokens[j+2]})
+		}
+	}
+	if len(tokens) >= 3 && isKeyword(tokens[0], "SET") && isKeyword(tokens[1], "ROLE") && !isKeyword(tokens[2], "NONE") {
+		reqs = append(reqs, privilegeRequirement{Kind: "member", Object: tokens[2]})
+	}
+	if len(tokens) >= 4 && isKeyword(tokens[0], "SET") && isKeyword(tokens[1], "LOCAL") && isKeyword(tokens[2], "ROLE") && !isKeyword(tokens[3], "NONE") {
+		reqs = append(reqs, privilegeRequirement{Kind: "member", Object: tokens[3]})
+	}
+
+	return reqs
+}
+
+// Turns an identifier as written into the name PostgreSQL stores: quoted
+// identifiers keep their case, unquoted ones are folded to lower case
+func unquoteIdent(ident string) string {
+	if len(ident) >= 2 && ident[0] == '"' && ident[len(ident)-1] == '"' {
+		return strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+	}
+	return strings.ToLower(ident)
+}
+
+// Checks a requirement against the database. Objects that don't exist yet
+// (created by an earlier pending statement) are assumed to be fine.
+func hasPrivilege(ctx context.Context, db *sql.DB, req privilegeRequirement) (bool, error) {
+	var ok sql.NullBool
+	var err error
+	switch req.Kind {
+	case "create-in-schema":
+		if req.Object == "" {
+			err = db.QueryRowContext(ctx, `SELECT has_schema_privilege(current_schema(), 'CREATE')`).Scan(&ok)
+		} else {
+			err = db.QueryRowContext(ctx,
+				`SELECT has_schema_privilege(n, 'CREATE') FROM to_regnamespace($1) n WHERE n IS NOT NULL`,
+				req.Object).Scan(&ok)
+		}
+	case "create-in-database":
+		err = db.QueryRowContext(ctx, `SELECT has_database_privilege(current_database(), 'CREATE')`).Scan(&ok)
+	case "owner":
+		err = db.QueryRowContext(ctx,
+			`SELECT pg_has_role(c.relowner, 'USAGE') FROM pg_class c WHERE c.oid = to_regclass($1)`,
+			req.Object).Scan(&ok)
+	case "member":
+		err = db.QueryRowContext(ctx,
+			`SELECT pg_has_role(oid, 'MEMBER') FROM pg_roles WHERE rolname = $1`,
+			unquoteIdent(req.Object)).Scan(&ok)
+	}
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !ok.Valid || ok.Bool, nil
+}
+
+// Verifies up front that the connecting role has every privilege the pending
+// statements need, failing with one consolidated report instead of dying on
+// statement 17 of 30
+func checkPrivileges(ctx context.Context, db *sql.DB, migrations []Migration) error {
+	var superuser bool
+	var role string
+	err := db.QueryRowContext(ctx,
+		`SELECT current_user, rolsuper FROM pg_roles WHERE rolname = current_user`).Scan(&role, &superuser)
+	if err != nil {
+		return err
+	}
+	if superuser {
+		return nil
+	}
+
+	var problems []string
+	checked := map[privilegeRequirement]bool{}
+	for _, m := range migrations {
+		// The statements run as the -- run-as role, which we can only
+		// check we may switch to
+		if m.Directives.RunAs != "" {
+			req := privilegeRequirement{Kind: "member", Object: m.Directives.RunAs}
+			if ok, err := hasPrivilege(ctx, db, req); err != nil {
+				return err
+			} else if !ok {
+				problems = append(problems, fmt.Sprintf("%s (%s), -- run-as: needs %s", m.Version, m.Name, req))
+			}
+			continue
+		}
+		for i, stmt := range m.Statements {
+			for _, req := range privilegeRequirements(stmt) {
+				ok, seen := checked[req]
+				if !seen {
+					if ok, err = hasPrivilege(ctx, db, req); err != nil {
+						return err
+					}
+					checked[req] = ok
+				}
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s (%s), statement %d: needs %s", m.Version, m.Name, i+1, req))
+				}
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("role %s lacks privileges required by pending migrations:\n  - %s", role, strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultProfilesFile = ".supabase-migrate.yaml"
+
+// Settings of one environment in the profiles file. Values can reference
+// environment variables as $VAR or ${VAR}, so secrets stay out of the file.
+type envProfile struct {
+	DatabaseURL   string `yaml:"database_url"`
+	MigrationsDir string `yaml:"migrations_dir"`
+	// Flag defaults by flag name, e.g. lock-wait: 10m
+	Options map[string]any `yaml:"options"`
+}
+
+type profilesFile struct {
+	Environments map[string]envProfile `yaml:"environments"`
+}
+
+// Applies the --env environment of the profiles file: its database URL
+// replaces DATABASE_URL, and its migrations directory and options become the
+// defaults of the flags not given on the command line. Without --env, or
+// without the file at its default path, nothing changes.
+func applyProfile(path, env string, explicitPath bool) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicitPath {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if env == "" {
+		return nil
+	}
+	var f profilesFile
+	if err := yaml.Unmarshal(raw, &f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	profile, ok := f.Environments[env]
+	if !ok {
+		names := make([]string, 0, len(f.Environments))
+		for name := range f.Environments {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%s: no environment %q (defined: %s)", path, env, strings.Join(names, ", "))
+	}
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	options := map[string]string{}
+	for name, value := range profile.Options {
+		options[name] = fmt.Sprint(value)
+	}
+	if profile.MigrationsDir != "" {
+		options["migrations-dir"] = profile.MigrationsDir
+	}
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "env" || name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: environment %s: unknown option %q", path, env, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		value, err := expandProfileValue(options[name])
+		if err != nil {
+			return fmt.Errorf("%s: environment %s: option %s: %v", path, env, name, err)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: environment %s: option %s: %v", path, env, name, err)
+		}
+	}
+
+	if profile.DatabaseURL != "" {
+		url, err := expandProfileValue(profile.DatabaseURL)
+		if err != nil {
+			return fmt.Errorf("%s: environment %s: database_url: %v", path, env, err)
+		}
+		os.Setenv("DATABASE_URL", url)
+	}
+	return nil
+}
+
+// Expands $VAR and ${VAR} references, failing on unset variables rather than
+// silently connecting somewhere else
+func expandProfileValue(value string) (string, error) {
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
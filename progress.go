@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Statements of -- no-transaction migrations that succeeded while the
+// migration as a whole hasn't yet, so a rerun resumes after them
+const progressTable = "schema_migrations_progress"
+
+func ensureProgressTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			version text NOT NULL,
+			statement integer NOT NULL,
+			hash text NOT NULL,
+			completed_at timestamptz NOT NULL DEFAULT now(),
+			PRIMARY KEY (version, statement)
+		)
+	`, schemaName, progressTable))
+	return err
+}
+
+// Returns statement index -> hash of the statements of a migration that
+// completed in an earlier, interrupted attempt
+func completedStatements(ctx context.Context, db *sql.DB, version string) (map[int]string, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT statement, hash FROM %s.%s WHERE version = $1`, schemaName, progressTable), version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int]string{}
+	for rows.Next() {
+		var i int
+		var hash string
+		if err := rows.Scan(&i, &hash); err != nil {
+			return nil, err
+		}
+		done[i] = hash
+	}
+	return done, rows.Err()
+}
+
+// Records that statement i of a -- no-transaction migration succeeded. The
+// hash makes sure only the same statement is skipped on resume, in case the
+// file was edited after the failure.
+func recordStatementProgress(ctx context.Context, db *sql.DB, version string, i int, stmt string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s.%s (version, statement, hash) VALUES ($1, $2, $3)
+		ON CONFLICT (version, statement) DO UPDATE SET hash = EXCLUDED.hash, completed_at = now()
+	`, schemaName, progressTable), version, i, migrate.Hash(stmt))
+	return err
+}
+
+// Forgets the progress of a migration once it is recorded as applied
+func clearProgress(ctx context.Context, ex execer, version string) error {
+	_, err := ex.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s.%s WHERE version = $1`, schemaName, progressTable), version)
+	return err
+}
+
+// Reports whether statement i ran in an earlier attempt of the migration
+func (r *applyRun) alreadyRan(i int, stmt string) bool {
+	hash, ok := r.resumed[i]
+	return ok && hash == migrate.Hash(stmt)
+}
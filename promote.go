@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reads the applied migrations of the database at dbURL
+func fetchAppliedAt(ctx context.Context, dbURL string) (map[string]string, error) {
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return fetchAppliedMigrations(ctx, db)
+}
+
+// Rehearses a production release on staging: the pending set is computed from
+// production, which is only read, and applied to staging, which must start
+// from production's state. Afterwards staging's history has to be exactly
+// production's plus the pending migrations.
+func runPromote(opts options, productionURL, stagingURL string) error {
+	if productionURL == "" {
+		return fmt.Errorf("promote needs --production-url (or PRODUCTION_DATABASE_URL)")
+	}
+	if target := stateTarget(stagingURL); target != "" && target == stateTarget(productionURL) {
+		return fmt.Errorf("--production-url and DATABASE_URL point at the same database (%s)", target)
+	}
+	warnWithoutTLS(productionURL)
+	ctx := context.Background()
+
+	production, err := fetchAppliedAt(ctx, productionURL)
+	if err != nil {
+		return fmt.Errorf("reading production history: %v", err)
+	}
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+
+	// Production's expected state after the release
+	expected := map[string]string{}
+	for version, hash := range production {
+		expected[version] = hash
+	}
+	var pending []string
+	for _, m := range migrations {
+		if _, ok := production[m.Version]; ok || m.Directives.RunAlways {
+			continue
+		}
+		pending = append(pending, m.Version)
+		expected[m.Version] = m.Hash
+	}
+	if len(pending) == 0 {
+		logInfo("Production is up to date; nothing to promote.")
+		return nil
+	}
+	logInfo("Production is missing %d migration(s): %s", len(pending), strings.Join(pending, ", "))
+
+	staging, err := fetchAppliedAt(ctx, stagingURL)
+	if err != nil {
+		return fmt.Errorf("reading staging history: %v", err)
+	}
+	var behind, foreign []string
+	for version := range production {
+		if _, ok := staging[version]; !ok {
+			behind = append(behind, version)
+		}
+	}
+	for version := range staging {
+		if _, ok := expected[version]; !ok {
+			foreign = append(foreign, version)
+		} else if _, ok := production[version]; !ok {
+			logWarn("Warning: %s is already applied on staging, so this rehearsal doesn't run it", version)
+		}
+	}
+	sort.Strings(behind)
+	sort.Strings(foreign)
+	if len(behind) > 0 {
+		return fmt.Errorf("staging is missing migrations production has (%s); refresh staging from production first", strings.Join(behind, ", "))
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("staging has migrations that are neither in production nor pending (%s); refresh staging from production first", strings.Join(foreign, ", "))
+	}
+
+	logInfo("Applying to staging...")
+	if _, err := runApply(opts, stagingURL); err != nil {
+		return err
+	}
+
+	staging, err = fetchAppliedAt(ctx, stagingURL)
+	if err != nil {
+		return fmt.Errorf("reading staging history: %v", err)
+	}
+	var problems []string
+	for version, want := range expected {
+		hash, ok := staging[version]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: not applied on staging", version))
+		case hash != want:
+			problems = append(problems, fmt.Sprintf("%s: applied on staging with a different hash", version))
+		}
+	}
+	for version := range staging {
+		if _, ok := expected[version]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: applied on staging but not expected in production", version))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("staging does not match production's next state:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	logInfo("Staging matches production's next state (%d migrations, %d new).", len(expected), len(pending))
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Variables defined before every migration, as if by \set; set to a fleet
+// target's vars while migrating it
+var psqlPresets map[string]string
+
+// Expands the subset of psql meta-commands DBAs commonly use in shared
+// scripts: `\set name value` lines define variables (and are removed), and
+// :name, :'name' (literal) and :"name" (identifier) are substituted outside
+// strings, comments and dollar-quoted bodies. Like psql, references to
+// undefined variables are left as they are. Any other meta-command is an error.
+func expandPsqlVariables(sql string) (string, error) {
+	vars := maps.Clone(psqlPresets)
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	var b strings.Builder
+	lineStart := true
+	for i := 0; i < len(sql); {
+		c := sql[i]
+
+		if lineStart {
+			rest := strings.TrimLeft(sql[i:], " \t")
+			if strings.HasPrefix(rest, `\`) {
+				end := strings.IndexByte(rest, '\n')
+				line := rest
+				if end >= 0 {
+					line = rest[:end]
+				}
+				if err := psqlMetaCommand(strings.TrimSpace(line), vars); err != nil {
+					return "", err
+				}
+				i += len(sql[i:]) - len(rest) + len(line)
+				continue
+			}
+		}
+		lineStart = c == '\n'
+
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			b.WriteString(sql[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := migrate.SkipBlockComment(sql, i)
+			b.WriteString(sql[i:end])
+			i = end
+		case c == '\'' || c == '"':
+			end, _ := migrate.SkipQuoted(sql, i)
+			b.WriteString(sql[i:end])
+			i = end
+		case c == '$':
+			if tag, ok := migrate.DollarQuoteTag(sql[i:]); ok {
+				closing := strings.Index(sql[i+len(tag):], tag)
+				end := len(sql)
+				if closing >= 0 {
+					end = i + len(tag) + closing + len(tag)
+				}
+				b.WriteString(sql[i:end])
+				i = end
+				continue
+			}
+			b.WriteByte(c)
+			i++
+		case c == ':' && !(i > 0 && sql[i-1] == ':') && !strings.HasPrefix(sql[i:], "::"):
+			if expanded, n, ok := psqlReference(sql[i:], vars); ok {
+				b.WriteString(expanded)
+				i += n
+				continue
+			}
+			b.WriteByte(c)
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// Handles one meta-command line; only \set is supported
+func psqlMetaCommand(line string, vars map[string]string) error {
+	fields := strings.Fields(line)
+	if fields[0] != `\set` {
+		return fmt.Errorf("unsupported psql meta-command %s (only \\set is supported)", fields[0])
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf(`\set requires a variable name`)
+	}
+	name := fields[1]
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(line, `\set`)), name))
+	if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+		value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	vars[name] = value
+	return nil
+}
+
+// Expands a reference at the start of s. Returns the expansion and the
+// number of bytes consumed.
+func psqlReference(s string, vars map[string]string) (string, int, bool) {
+	quote := byte(0)
+	start := 1
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		quote = s[1]
+		start = 2
+	}
+
+	end := start
+	for end < len(s) {
+		c := s[end]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (end > start && c >= '0' && c <= '9') {
+			end++
+			continue
+		}
+		break
+	}
+	if end == start {
+		return "", 0, false
+	}
+	name := s[start:end]
+	value, ok := vars[name]
+	if !ok {
+		return "", 0, false
+	}
+
+	switch quote {
+	case '\'':
+		if end >= len(s) || s[end] != '\'' {
+			return "", 0, false
+		}
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'", end + 1, true
+	case '"':
+		if end >= len(s) || s[end] != '"' {
+			return "", 0, false
+		}
+		return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`, end + 1, true
+	default:
+		return value, end, true
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+const (
+	managementAPI = "https://api.supabase.com"
+	// Where `supabase link` records the linked project
+	linkedProjectFile = "./supabase/.temp/project-ref"
+)
+
+// Runs SQL through the Supabase Management API's database query endpoint, for
+// CI networks that can reach HTTPS but not Postgres. Every call is a separate
+// session; several statements in one call run as one implicit transaction.
+type managementClient struct {
+	ref   string
+	token string
+	http  *http.Client
+}
+
+// Runs sql and decodes the rows of its last statement into out, if not nil
+func (c *managementClient) query(sql string, out any) error {
+	body, err := json.Marshal(map[string]string{"query": sql})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/projects/%s/database/query", managementAPI, c.ref), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("management API: %s", apiErr.Message)
+		}
+		return fmt.Errorf("management API returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// Quotes a string literal for SQL sent without parameters
+func sqlLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Returns the project ref from --project-ref, or from `supabase link`
+func linkedProjectRef(opts options) (string, error) {
+	if opts.projectRef != "" {
+		return opts.projectRef, nil
+	}
+	b, err := os.ReadFile(linkedProjectFile)
+	if err != nil {
+		return "", fmt.Errorf("no linked project: run `supabase link` or pass --project-ref (%v)", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Applies pending migrations over HTTPS with `push --linked`, writing the same
+// history rows as apply. Each migration and its history row are sent as one
+// call, so they commit together; -- no-transaction migrations send each
+// statement on its own and then the row.
+func runPush(opts options) error {
+	if !opts.linked {
+		return fmt.Errorf("push requires --linked (use apply for a direct connection)")
+	}
+	token := os.Getenv("SUPABASE_ACCESS_TOKEN")
+	if token == "" {
+		return fmt.Errorf("push --linked requires SUPABASE_ACCESS_TOKEN")
+	}
+	ref, err := linkedProjectRef(opts)
+	if err != nil {
+		return err
+	}
+	c := &managementClient{ref: ref, token: token, http: &http.Client{Timeout: 10 * time.Minute}}
+	logInfo("Pushing to project %s through the Management API...", ref)
+
+	err = c.query(fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %[1]s;
+		CREATE TABLE IF NOT EXISTS %[1]s.%[2]s (
+			version TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			statements TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			created_by TEXT,
+			idempotency_key TEXT
+		);
+		ALTER TABLE %[1]s.%[2]s ADD COLUMN IF NOT EXISTS statement_count INT;
+	`, schemaName, tableName), nil)
+	if err != nil {
+		return fmt.Errorf("error creating table: %v", err)
+	}
+
+	// Sessions don't outlive a call, so the lease lock can't be held; refuse
+	// to run next to a runner that holds it. The query fails when no runner
+	// ever created the lock table.
+	var runners []struct {
+		Holder string `json:"holder"`
+	}
+	err = c.query(fmt.Sprintf(`
+		SELECT holder FROM %s.%s WHERE id = 1 AND expires_at > NOW()
+	`, schemaName, lockTableName), &runners)
+	if err == nil && len(runners) > 0 {
+		return fmt.Errorf("another runner is active: %s", runners[0].Holder)
+	}
+
+	var rows []struct {
+		Version  string  `json:"version"`
+		Hash     string  `json:"hash"`
+		Baseline *string `json:"baseline"`
+	}
+	err = c.query(fmt.Sprintf(`
+		SELECT version, hash, CASE WHEN created_by = %s THEN array_to_string(statements, E'\n') END AS baseline
+		FROM %s.%s
+	`, sqlLiteral(baselineCreatedBy), schemaName, tableName), &rows)
+	if err != nil {
+		return err
+	}
+	applied := map[string]string{}
+	for _, r := range rows {
+		if r.Baseline != nil {
+			for v, h := range migrate.ParseBaseline(*r.Baseline) {
+				applied[v] = h
+			}
+			continue
+		}
+		applied[r.Version] = r.Hash
+	}
+
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok && !m.Directives.RunAlways {
+			pending = append(pending, m)
+		}
+	}
+	if err := checkTransactionSafety(pending); err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if m.Directives.RunAs != "" || m.Directives.Parallel || len(m.Directives.Backfills) > 0 || m.Directives.SkipIf != "" {
+			return fmt.Errorf("%s (%s) uses -- run-as, -- parallel, -- backfill or -- skip-if, which need a direct connection; use apply", m.Version, m.Name)
+		}
+	}
+	key, err := loadHistoryKey()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		logInfo("Applying pending migration: %s (%s)", m.Version, m.Name)
+		recorded := m.Statements
+		if opts.noStoreStatements {
+			recorded = nil
+		} else if key != nil {
+			if recorded, err = encryptStatements(key, m.Statements); err != nil {
+				return err
+			}
+		}
+		insert := fmt.Sprintf(`
+			INSERT INTO %s.%s (version, name, hash, statements, created_by, idempotency_key, statement_count)
+			VALUES (%s, %s, %s, %s::text[], 'supabase-direct-migrate', NULL, %d)
+		`, schemaName, tableName, sqlLiteral(m.Version), sqlLiteral(m.Name), sqlLiteral(m.Hash),
+			sqlLiteral(formatPostgresArray(recorded)), len(m.Statements))
+
+		if m.Directives.NoTransaction {
+			for i, stmt := range m.Statements {
+				logDebug("Executing statement %d/%d of %s:\n%s", i+1, len(m.Statements), m.Version, stmt)
+				if err := c.query(stmt, nil); err != nil {
+					return fmt.Errorf("%s, statement %d: %v", m.Version, i+1, err)
+				}
+			}
+			err = c.query(insert, nil)
+		} else {
+			err = c.query("BEGIN;\n"+strings.Join(m.Statements, "\n;\n")+"\n;\n"+insert+";\nCOMMIT;", nil)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.Version, err)
+		}
+		logInfo("Migration %s applied successfully.", m.Version)
+	}
+	logInfo("All pending migrations have been applied.")
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const realtimePublication = "supabase_realtime"
+
+// A table to add to or remove from the realtime publication
+type realtimeChange struct {
+	Action string // "add" or "remove"
+	Table  string
+}
+
+// Parses the value of a -- realtime directive: "add public.messages, rooms"
+func parseRealtimeDirective(value string) ([]realtimeChange, error) {
+	action, tables, _ := strings.Cut(strings.TrimSpace(value), " ")
+	action = strings.ToLower(action)
+	if action != "add" && action != "remove" {
+		return nil, fmt.Errorf("expected add or remove, got %q", action)
+	}
+
+	var changes []realtimeChange
+	for _, t := range strings.Split(tables, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			changes = append(changes, realtimeChange{Action: action, Table: t})
+		}
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no tables listed")
+	}
+	return changes, nil
+}
+
+// Brings tables in or out of the supabase_realtime publication. Changes are
+// declarative: adding a table that is already published, or removing one
+// that isn't, does nothing, so the same migration works on every environment.
+// Self-hosted setups don't always create the publication, so its absence is a
+// clear error rather than a failed ALTER PUBLICATION.
+func applyRealtimeChanges(ctx context.Context, ex execer, changes []realtimeChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var allTables sql.NullBool
+	err := ex.QueryRowContext(ctx,
+		`SELECT puballtables FROM pg_publication WHERE pubname = $1`, realtimePublication).Scan(&allTables)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("publication %s does not exist; create it with CREATE PUBLICATION %s", realtimePublication, realtimePublication)
+	}
+	if err != nil {
+		return err
+	}
+	if allTables.Bool {
+		return fmt.Errorf("publication %s is FOR ALL TABLES; tables can't be added or removed individually", realtimePublication)
+	}
+
+	for _, c := range changes {
+		var relation sql.NullString
+		var member bool
+		err := ex.QueryRowContext(ctx, `
+			SELECT to_regclass($2)::text, EXISTS(
+				SELECT 1 FROM pg_publication_rel pr
+				JOIN pg_publication p ON p.oid = pr.prpubid
+				WHERE p.pubname = $1 AND pr.prrelid = to_regclass($2)
+			)
+		`, realtimePublication, c.Table).Scan(&relation, &member)
+		if err != nil {
+			return err
+		}
+		if !relation.Valid {
+			return fmt.Errorf("table %s does not exist", c.Table)
+		}
+
+		switch {
+		case c.Action == "add" && !member:
+			if _, err := ex.ExecContext(ctx, fmt.Sprintf(`ALTER PUBLICATION %s ADD TABLE %s`, realtimePublication, relation.String)); err != nil {
+				return err
+			}
+			logInfo("Added %s to publication %s.", c.Table, realtimePublication)
+		case c.Action == "remove" && member:
+			if _, err := ex.ExecContext(ctx, fmt.Sprintf(`ALTER PUBLICATION %s DROP TABLE %s`, realtimePublication, relation.String)); err != nil {
+				return err
+			}
+			logInfo("Removed %s from publication %s.", c.Table, realtimePublication)
+		}
+	}
+	return nil
+}
+
+// Handles `realtime list`, `realtime add TABLE...` and `realtime remove TABLE...`
+func runRealtime(dbURL string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("realtime requires list, add or remove")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		rows, err := db.QueryContext(ctx,
+			`SELECT schemaname, tablename FROM pg_publication_tables WHERE pubname = $1 ORDER BY 1, 2`,
+			realtimePublication)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var schema, table string
+			if err := rows.Scan(&schema, &table); err != nil {
+				return err
+			}
+			fmt.Printf("%s.%s\n", schema, table)
+		}
+		return rows.Err()
+	case "add", "remove":
+		if len(args) < 2 {
+			return fmt.Errorf("realtime %s requires at least one table", args[0])
+		}
+		var changes []realtimeChange
+		for _, t := range args[1:] {
+			changes = append(changes, realtimeChange{Action: args[0], Table: t})
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := applyRealtimeChanges(ctx, tx, changes); err != nil {
+			return err
+		}
+		return tx.Commit()
+	default:
+		return fmt.Errorf("unknown realtime action %q (expected list, add or remove)", args[0])
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type rebaseRename struct {
+	from Migration
+	to   string // new version
+}
+
+// Plans new versions for pending migrations so that they all sort after the
+// latest applied version, keeping their relative order. Migrations that are
+// already in order keep their version. Versions in taken (the local and
+// applied ones) are never assigned, so a new version can't collide with a
+// file that keeps it.
+func planRebase(pending []Migration, latestApplied string, taken map[string]bool) ([]rebaseRename, error) {
+	prev, ok := parseVersionTime(latestApplied)
+	if !ok {
+		return nil, fmt.Errorf("latest applied version %s is not a timestamp", latestApplied)
+	}
+
+	var renames []rebaseRename
+	for _, m := range pending {
+		if t, ok := parseVersionTime(m.Version); ok && t.After(prev) {
+			prev = t
+			continue
+		}
+		prev = prev.Add(time.Second)
+		for taken[prev.Format(versionLayout)] {
+			prev = prev.Add(time.Second)
+		}
+		renames = append(renames, rebaseRename{from: m, to: prev.Format(versionLayout)})
+	}
+	return renames, nil
+}
+
+// Re-timestamps local, not yet applied migrations to be newer than the latest
+// applied version: renames every file of each migration and rewrites
+// references to the old filename or version in the other pending migrations.
+// Applied migrations are never touched, since that would change their hash.
+func runRebase(opts options) error {
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+
+	applied, source, err := referenceVersions(opts)
+	if err != nil {
+		return err
+	}
+	latest := latestVersion(applied)
+	if latest == "" {
+		logInfo("Nothing is applied in %s; no rebase needed.", source)
+		return nil
+	}
+
+	var pending []Migration
+	taken := map[string]bool{}
+	for v := range applied {
+		taken[v] = true
+	}
+	for _, m := range migrations {
+		taken[m.Version] = true
+		if _, ok := applied[m.Version]; !ok && !m.Directives.RunAlways {
+			pending = append(pending, m)
+		}
+	}
+
+	renames, err := planRebase(pending, latest, taken)
+	if err != nil {
+		return err
+	}
+	if len(renames) == 0 {
+		logInfo("All %d pending migrations are already newer than %s.", len(pending), latest)
+		return nil
+	}
+
+	files, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	// Old -> new for every file of a rebased migration, plus bare versions
+	replacements := map[string]string{}
+	var filePairs, versionPairs []string
+	for _, r := range renames {
+		prefix := r.from.Version + "_"
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), prefix) {
+				replacements[f.Name()] = r.to + "_" + strings.TrimPrefix(f.Name(), prefix)
+				filePairs = append(filePairs, f.Name(), replacements[f.Name()])
+			}
+		}
+		versionPairs = append(versionPairs, r.from.Version, r.to)
+	}
+	// One simultaneous pass, so a reference rewritten to a new version isn't
+	// rewritten again when that version was also an old one. At the same
+	// position filenames are tried before the bare versions they start with.
+	rewrite := strings.NewReplacer(append(filePairs, versionPairs...)...)
+
+	for _, r := range renames {
+		prefix := r.from.Version + "_"
+		for _, f := range files {
+			if !strings.HasPrefix(f.Name(), prefix) {
+				continue
+			}
+			oldPath := filepath.Join(migrationsDir, f.Name())
+			newPath := filepath.Join(migrationsDir, replacements[f.Name()])
+			if err := os.Rename(oldPath, newPath); err != nil {
+				return err
+			}
+			logInfo("Renamed %s -> %s", f.Name(), replacements[f.Name()])
+		}
+	}
+
+	// Update references in pending migrations (now possibly renamed)
+	for _, m := range pending {
+		name := m.Version + "_" + m.Name
+		if renamed, ok := replacements[name]; ok {
+			name = renamed
+		}
+		path := filepath.Join(migrationsDir, name)
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content := rewrite.Replace(string(raw))
+		if content != string(raw) {
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				return err
+			}
+			logInfo("Updated references in %s", name)
+		}
+	}
+
+	logInfo("Rebased %d migrations onto %s (latest in %s).", len(renames), latest, source)
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Handles `repair hashes`, and `repair` with --rehash, --remove-missing or
+// --mark-applied
+func runRepair(opts options, dbURL string, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "hashes":
+		return repairHashes(opts, dbURL)
+	case len(args) == 0:
+		return repairHistory(opts, dbURL)
+	default:
+		return fmt.Errorf("repair takes hashes, or no argument with --rehash, --remove-missing or --mark-applied")
+	}
+}
+
+// With --normalize-hash, rewrites the recorded hash of applied migrations
+// whose file still matches it byte for byte to the normalized hash, so
+// turning normalization on doesn't report every applied migration as
+// changed. Rows whose file really changed are left alone.
+func repairHashes(opts options, dbURL string) error {
+	if !normalizeHashes {
+		return fmt.Errorf("repair hashes rewrites hashes to the normalized form; run it with --normalize-hash")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	lock, err := acquireMigrationLock(ctx, db, opts)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer lock.release(ctx)
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	files, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	repaired, unchanged, drifted := 0, 0, 0
+	for _, f := range files {
+		if f.IsDir() || !isMigrationFile(f.Name()) {
+			continue
+		}
+		version, _, _ := strings.Cut(f.Name(), "_")
+		recorded, ok := applied[version]
+		if !ok {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(migrationsDir, f.Name()))
+		if err != nil {
+			return err
+		}
+		m, err := parseMigration(f.Name(), string(raw))
+		if err != nil {
+			return err
+		}
+		normalized := m.Hash
+		switch recorded {
+		case normalized:
+			unchanged++
+		case migrate.Hash(string(raw)):
+			res, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s.%s SET hash = $2 WHERE version = $1`, schemaName, tableName), version, normalized)
+			if err != nil {
+				return err
+			}
+			// Versions covered by a baseline row have no row of their own
+			if n, _ := res.RowsAffected(); n == 0 {
+				logWarn("Warning: %s is recorded in the archive baseline; its hash was not repaired", version)
+				continue
+			}
+			repaired++
+		default:
+			logWarn("Warning: %s (%s) changed since it was applied; its hash was not repaired", version, f.Name())
+			drifted++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logInfo("Repaired %d hash(es); %d already normalized, %d changed since applied.", repaired, unchanged, drifted)
+	return nil
+}
+
+// Fixes the history by hand after it drifted from the migrations directory,
+// one kind of fix per flag:
+//
+//   - --rehash records the hash of the local file for applied migrations
+//     that were edited after they ran
+//   - --remove-missing deletes the rows of versions without a local file
+//   - --mark-applied records versions that were applied out-of-band, e.g.
+//     by hand in the SQL editor, without running them
+//
+// The changes are listed and need confirming, or --yes, and are made in one
+// transaction.
+func repairHistory(opts options, dbURL string) error {
+	var markVersions []string
+	for _, v := range strings.Split(opts.markApplied, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			markVersions = append(markVersions, v)
+		}
+	}
+	if !opts.rehash && !opts.removeMissing && len(markVersions) == 0 {
+		return fmt.Errorf("repair needs --rehash, --remove-missing or --mark-applied VERSIONS")
+	}
+
+	key, err := loadHistoryKey()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	lock, err := acquireMigrationLock(ctx, db, opts)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer lock.release(ctx)
+
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+	local := map[string]Migration{}
+	for _, m := range migrations {
+		local[m.Version] = m
+	}
+
+	// Only rows of their own; versions covered by the archive baseline have
+	// no file on purpose
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version, name, hash FROM %s.%s WHERE created_by IS DISTINCT FROM $1 ORDER BY version
+	`, schemaName, tableName), baselineCreatedBy)
+	if err != nil {
+		return err
+	}
+	type row struct{ version, name, hash string }
+	var recorded []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.version, &r.name, &r.hash); err != nil {
+			rows.Close()
+			return err
+		}
+		recorded = append(recorded, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	var rehash []Migration
+	var remove []row
+	var mark []Migration
+	for _, r := range recorded {
+		m, ok := local[r.version]
+		switch {
+		case !ok && opts.removeMissing:
+			remove = append(remove, r)
+		case ok && opts.rehash && !m.Directives.RunAlways && r.hash != m.Hash:
+			rehash = append(rehash, m)
+		}
+	}
+	for _, v := range markVersions {
+		m, ok := local[v]
+		if !ok {
+			return fmt.Errorf("--mark-applied: no local migration with version %s", v)
+		}
+		if m.Directives.RunAlways {
+			return fmt.Errorf("--mark-applied: %s is a run-always migration, which isn't recorded", v)
+		}
+		if _, ok := applied[v]; ok {
+			return fmt.Errorf("--mark-applied: %s is already recorded as applied", v)
+		}
+		mark = append(mark, m)
+	}
+	sort.Slice(mark, func(i, j int) bool { return mark[i].Version < mark[j].Version })
+
+	if len(rehash)+len(remove)+len(mark) == 0 {
+		logInfo("Nothing to repair.")
+		return nil
+	}
+	for _, m := range rehash {
+		fmt.Printf("  rehash  %s (%s): record the hash of the local file\n", m.Version, m.Name)
+	}
+	for _, r := range remove {
+		fmt.Printf("  remove  %s (%s): no local file\n", r.version, r.name)
+	}
+	for _, m := range mark {
+		fmt.Printf("  mark    %s (%s): record as applied without running it\n", m.Version, m.Name)
+	}
+	if !opts.yes && !confirm("Repair the history as listed?") {
+		return fmt.Errorf("repair cancelled; nothing was changed")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range rehash {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s.%s SET hash = $2 WHERE version = $1`, schemaName, tableName), m.Version, m.Hash); err != nil {
+			return err
+		}
+	}
+	for _, r := range remove {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s.%s WHERE version = $1`, schemaName, tableName), r.version); err != nil {
+			return err
+		}
+	}
+	for _, m := range mark {
+		if err := recordWithoutRunning(ctx, tx, opts, key, m, "supabase-direct-migrate repair"); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logInfo("Repaired the history: %d rehashed, %d removed, %d marked applied.", len(rehash), len(remove), len(mark))
+	return nil
+}
+
+// Records a migration as applied without running it, with its statements
+// stored the way apply stores them
+func recordWithoutRunning(ctx context.Context, ex execer, opts options, key []byte, m Migration, createdBy string) error {
+	recorded := m.Statements
+	if opts.noStoreStatements {
+		recorded = nil
+	} else if key != nil {
+		var err error
+		if recorded, err = encryptStatements(key, m.Statements); err != nil {
+			return err
+		}
+	}
+	_, err := ex.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s.%s (version, name, hash, statements, created_by, statement_count)
+		VALUES ($1, $2, $3, $4::text[], $5, $6)
+	`, schemaName, tableName), m.Version, m.Name, m.Hash, formatPostgresArray(recorded), createdBy, len(m.Statements))
+	return err
+}
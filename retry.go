@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Upper bound of the doubling wait between retries
+const maxRetryInterval = 30 * time.Second
+
+// SQLSTATEs worth retrying: serialization failures, deadlocks, and the server
+// shutting down or restarting under us
+var transientCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"08000": true, // connection_exception
+	"08003": true, // connection_does_not_exist
+	"08006": true, // connection_failure
+}
+
+// Reports whether err is likely to go away if the same work is tried again
+func isTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientCodes[pgErr.Code]
+	}
+	var connErr *pgconn.ConnectError
+	return errors.As(err, &connErr) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Wait before retry attempt n (1-based): --retry-interval, doubled for each
+// further attempt, capped at maxRetryInterval
+func retryDelay(interval time.Duration, attempt int) time.Duration {
+	d := interval
+	for i := 1; i < attempt && d < maxRetryInterval; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryInterval)
+}
+
+// Checks the database can be reached, retrying up to --connect-retries times
+// with backoff, for databases that are still waking up (paused projects,
+// serverless cold starts)
+func connectWithRetry(ctx context.Context, db *sql.DB, retries int, interval time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil || attempt > retries || !isTransient(err) {
+			return err
+		}
+		delay := retryDelay(interval, attempt)
+		logDebug("Connection attempt %d failed: %v", attempt, err)
+		logWarn("Warning: could not connect to the database; retrying in %s (%d/%d)", delay, attempt, retries)
+		time.Sleep(delay)
+	}
+}
+
+// Applies a migration, running it again with backoff when a statement fails
+// with a transient error, up to --connect-retries times. A transactional
+// migration was rolled back by then and starts over; a -- no-transaction one
+// resumes after the statements that completed. Failures outside the
+// statements, e.g. while recording the migration, aren't retried since the
+// migration may have committed.
+func (r *applyRun) applyWithRetry(ctx context.Context, m Migration) error {
+	for attempt := 1; ; attempt++ {
+		err := r.applyMigration(ctx, m)
+		var se *statementError
+		if err == nil || attempt > r.opts.connectRetries || !errors.As(err, &se) || !isTransient(err) {
+			return err
+		}
+		// The server restarting also ends the session holding the lock
+		if r.lock.Err() != nil {
+			return err
+		}
+		delay := retryDelay(r.opts.retryInterval, attempt)
+		logWarn("Warning: migration %s hit a transient error (%v); retrying in %s (%d/%d)", m.Version, se.err, delay, attempt, r.opts.connectRetries)
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// A row security policy, as migrations define it or as pg_policies shows it
+type rlsPolicy struct {
+	Table      string // schema.table, unquoted
+	Name       string
+	Permissive string // PERMISSIVE or RESTRICTIVE
+	Command    string // ALL, SELECT, INSERT, UPDATE or DELETE
+	Roles      []string
+	Using      string
+	WithCheck  string
+}
+
+// Key identifying a policy: the table and the policy name
+func (p rlsPolicy) key() string {
+	return p.Table + " " + quoteIdentIfNeeded(p.Name)
+}
+
+// CREATE POLICY statement defining the policy
+func (p rlsPolicy) createSQL() string {
+	schema, table, _ := strings.Cut(p.Table, ".")
+	stmt := fmt.Sprintf("CREATE POLICY %s ON %s.%s AS %s FOR %s TO %s", quoteIdentIfNeeded(p.Name), quoteIdentIfNeeded(schema), quoteIdentIfNeeded(table),
+		p.Permissive, p.Command, strings.Join(p.Roles, ", "))
+	if p.Using != "" {
+		stmt += " USING (" + p.Using + ")"
+	}
+	if p.WithCheck != "" {
+		stmt += " WITH CHECK (" + p.WithCheck + ")"
+	}
+	return stmt + ";"
+}
+
+// Returns the name as schema.table, unquoted, with public for unqualified
+// names
+func policyTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = unquoteIdent(p)
+	}
+	if len(parts) == 1 {
+		return "public." + parts[0]
+	}
+	return strings.Join(parts, ".")
+}
+
+// Returns the tokens from the ( at i to its matching ), exclusive, and the
+// index after the )
+func parenthesized(cmd sqlCommand, i int) ([]string, int) {
+	if i >= len(cmd) || cmd[i] != "(" {
+		return nil, i
+	}
+	depth := 0
+	for j := i; j < len(cmd); j++ {
+		switch cmd[j] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return cmd[i+1 : j], j + 1
+			}
+		}
+	}
+	return cmd[i+1:], len(cmd)
+}
+
+// Reads the clauses of CREATE POLICY and ALTER POLICY from i into p. A
+// RENAME TO returns the new name.
+func readPolicyClauses(cmd sqlCommand, i int, p *rlsPolicy) (rename string) {
+	for i < len(cmd) {
+		switch {
+		case cmd.is(i, "RENAME", "TO"):
+			if i+2 < len(cmd) {
+				return unquoteIdent(cmd[i+2])
+			}
+			return ""
+		case cmd.is(i, "AS") && i+1 < len(cmd):
+			p.Permissive = strings.ToUpper(cmd[i+1])
+			i += 2
+		case cmd.is(i, "FOR") && i+1 < len(cmd):
+			p.Command = strings.ToUpper(cmd[i+1])
+			i += 2
+		case cmd.is(i, "TO"):
+			p.Roles = nil
+			i++
+			for i < len(cmd) {
+				p.Roles = append(p.Roles, unquoteIdent(cmd[i]))
+				i++
+				if i >= len(cmd) || cmd[i] != "," {
+					break
+				}
+				i++
+			}
+		case cmd.is(i, "USING"):
+			var expr []string
+			expr, i = parenthesized(cmd, i+1)
+			p.Using = joinTokens(expr)
+		case cmd.is(i, "WITH", "CHECK"):
+			var expr []string
+			expr, i = parenthesized(cmd, i+2)
+			p.WithCheck = joinTokens(expr)
+		default:
+			i++
+		}
+	}
+	return ""
+}
+
+// Replays the CREATE, ALTER and DROP POLICY commands of the migrations, and
+// the tables they drop, into the policies they leave behind. Policies created
+// inside functions or DO blocks can't be seen.
+func migrationPolicies(migrations []Migration) map[string]rlsPolicy {
+	policies := map[string]rlsPolicy{}
+	for _, m := range migrations {
+		for _, stmt := range m.Statements {
+			for _, cmd := range splitCommands(stmt) {
+				switch {
+				case cmd.is(0, "CREATE", "POLICY") && len(cmd) > 4:
+					on := cmd.find(3, "ON")
+					if on < 0 {
+						continue
+					}
+					table, next := readQualifiedName(cmd, on+1)
+					p := rlsPolicy{Table: policyTable(table), Name: unquoteIdent(cmd[2]), Permissive: "PERMISSIVE", Command: "ALL", Roles: []string{"public"}}
+					readPolicyClauses(cmd, next, &p)
+					policies[p.key()] = p
+				case cmd.is(0, "ALTER", "POLICY") && len(cmd) > 4:
+					on := cmd.find(3, "ON")
+					if on < 0 {
+						continue
+					}
+					table, next := readQualifiedName(cmd, on+1)
+					key := rlsPolicy{Table: policyTable(table), Name: unquoteIdent(cmd[2])}.key()
+					p, ok := policies[key]
+					if !ok {
+						continue
+					}
+					if rename := readPolicyClauses(cmd, next, &p); rename != "" {
+						delete(policies, key)
+						p.Name = rename
+					}
+					policies[p.key()] = p
+				case cmd.is(0, "DROP", "POLICY"):
+					i := cmd.skip(2, "IF", "EXISTS")
+					on := cmd.find(i, "ON")
+					if on < 0 || i >= len(cmd) {
+						continue
+					}
+					table, _ := readQualifiedName(cmd, on+1)
+					delete(policies, rlsPolicy{Table: policyTable(table), Name: unquoteIdent(cmd[i])}.key())
+				case cmd.is(0, "DROP", "TABLE"):
+					for _, t := range commandLockTargets(cmd) {
+						dropped := policyTable(t.Relation)
+						for key, p := range policies {
+							if p.Table == dropped {
+								delete(policies, key)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return policies
+}
+
+// Policies in the database, outside the system schemas
+func databasePolicies(ctx context.Context, db *sql.DB) (map[string]rlsPolicy, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT schemaname || '.' || tablename, policyname, permissive, cmd,
+			array_to_string(roles, ','), COALESCE(qual, ''), COALESCE(with_check, '')
+		FROM pg_policies
+		WHERE schemaname NOT IN ('pg_catalog', 'information_schema')
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies := map[string]rlsPolicy{}
+	for rows.Next() {
+		var p rlsPolicy
+		var roles string
+		if err := rows.Scan(&p.Table, &p.Name, &p.Permissive, &p.Command, &roles, &p.Using, &p.WithCheck); err != nil {
+			return nil, err
+		}
+		p.Roles = strings.Split(roles, ",")
+		policies[p.key()] = p
+	}
+	return policies, rows.Err()
+}
+
+// Canonical form of a policy expression for comparing what a migration wrote
+// with what PostgreSQL prints back: parentheses, casts, column aliases, case
+// and identifier quoting are ignored
+func normalizePolicyExpr(expr string) string {
+	tokens := migrate.Tokenize(expr)
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch {
+		case tok == "(" || tok == ")":
+			continue
+		case tok == ":" && i+1 < len(tokens) && tokens[i+1] == ":":
+			// Skip the type, including a qualified or array one
+			_, next := readQualifiedName(tokens, i+2)
+			for next+1 < len(tokens) && tokens[next] == "[" && tokens[next+1] == "]" {
+				next += 2
+			}
+			i = next - 1
+			continue
+		case isKeyword(tok, "AS") && i+1 < len(tokens) && migrate.IsWordByte(tokens[i+1][0]):
+			i++
+			continue
+		case tok[0] == '"':
+			out = append(out, quoteIdentIfNeeded(unquoteIdent(tok)))
+		case tok[0] == '\'' || tok[0] == '$':
+			out = append(out, tok)
+		default:
+			out = append(out, strings.ToLower(tok))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// Returns what differs between two definitions of a policy
+func policyDifferences(want, have rlsPolicy) []string {
+	var diffs []string
+	if want.Permissive != have.Permissive {
+		diffs = append(diffs, "as")
+	}
+	if want.Command != have.Command {
+		diffs = append(diffs, "for")
+	}
+	wantRoles, haveRoles := slices.Clone(want.Roles), slices.Clone(have.Roles)
+	sort.Strings(wantRoles)
+	sort.Strings(haveRoles)
+	if !slices.Equal(wantRoles, haveRoles) {
+		diffs = append(diffs, "to")
+	}
+	if normalizePolicyExpr(want.Using) != normalizePolicyExpr(have.Using) {
+		diffs = append(diffs, "using")
+	}
+	if normalizePolicyExpr(want.WithCheck) != normalizePolicyExpr(have.WithCheck) {
+		diffs = append(diffs, "with check")
+	}
+	return diffs
+}
+
+// Handles `check policies`: compares the row security policies the local
+// migrations define with the ones in the database, to find policies created
+// or edited in the dashboard and never captured in a migration. With --diff,
+// prints the differing clauses, and the CREATE POLICY of policies only in
+// the database. Returns false if they differ.
+func runCheckPolicies(opts options, dbURL string) (bool, error) {
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return false, err
+	}
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	// Pending migrations aren't in the database yet, so they don't count
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			done = append(done, m)
+		}
+	}
+	want := migrationPolicies(done)
+	have, err := databasePolicies(ctx, db)
+	if err != nil {
+		return false, err
+	}
+
+	keys := map[string]bool{}
+	for k := range want {
+		keys[k] = true
+	}
+	for k := range have {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	drift := 0
+	for _, k := range sorted {
+		w, inMigrations := want[k]
+		h, inDatabase := have[k]
+		switch {
+		case !inMigrations:
+			logInfo("+ %s: only in the database", k)
+			if opts.diff {
+				logInfo("    %s", h.createSQL())
+			}
+			drift++
+		case !inDatabase:
+			logInfo("- %s: only in the migrations", k)
+			drift++
+		default:
+			diffs := policyDifferences(w, h)
+			if len(diffs) == 0 {
+				continue
+			}
+			logInfo("~ %s: %s differ", k, strings.Join(diffs, ", "))
+			if opts.diff {
+				for _, d := range diffs {
+					logInfo("    - %s: %s", d, policyClause(w, d))
+					logInfo("    + %s: %s", d, policyClause(h, d))
+				}
+			}
+			drift++
+		}
+	}
+	if drift > 0 {
+		logError("%d policy(ies) differ from the applied migrations (+ only in the database, - only in the migrations, ~ edited).", drift)
+		return false, nil
+	}
+	logInfo("Policies match the applied migrations (%d).", len(have))
+	return true, nil
+}
+
+// Returns one clause of a policy, as policyDifferences names them
+func policyClause(p rlsPolicy, clause string) string {
+	switch clause {
+	case "as":
+		return p.Permissive
+	case "for":
+		return p.Command
+	case "to":
+		return strings.Join(p.Roles, ", ")
+	case "using":
+		return p.Using
+	default:
+		return p.WithCheck
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultGrantsFile = "./supabase/grants.sql"
+
+// Grantee of an aclexplode row; grantee 0 is PUBLIC
+const granteeName = `CASE WHEN a.grantee = 0 THEN 'PUBLIC' ELSE quote_ident(pg_get_userbyid(a.grantee)) END`
+
+// Role memberships, default privileges, and grants on the exposed schemas and
+// their tables, sequences and functions, each as the SQL statement that would
+// create it. Privileges owners hold on their own objects are left out.
+const grantsQuery = `
+	SELECT format('GRANT %I TO %I;', r.rolname, m.rolname)
+	FROM pg_auth_members am
+	JOIN pg_roles r ON r.oid = am.roleid
+	JOIN pg_roles m ON m.oid = am.member
+	UNION ALL
+	SELECT format('GRANT %s ON SCHEMA %I TO %s;', string_agg(a.privilege_type, ', ' ORDER BY a.privilege_type),
+		n.nspname, ` + granteeName + `)
+	FROM pg_namespace n, aclexplode(COALESCE(n.nspacl, acldefault('n', n.nspowner))) a
+	WHERE n.nspname = ANY($1::text[]) AND a.grantee <> n.nspowner
+	GROUP BY n.nspname, a.grantee
+	UNION ALL
+	SELECT format('GRANT %s ON %s %I.%I TO %s;', string_agg(a.privilege_type, ', ' ORDER BY a.privilege_type),
+		CASE c.relkind WHEN 'S' THEN 'SEQUENCE' ELSE 'TABLE' END, n.nspname, c.relname, ` + granteeName + `)
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace,
+	aclexplode(COALESCE(c.relacl, acldefault(CASE c.relkind WHEN 'S' THEN 's' ELSE 'r' END::"char", c.relowner))) a
+	WHERE n.nspname = ANY($1::text[]) AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S') AND a.grantee <> c.relowner
+	GROUP BY n.nspname, c.relname, c.relkind, a.grantee
+	UNION ALL
+	SELECT format('GRANT EXECUTE ON FUNCTION %I.%I(%s) TO %s;', n.nspname, p.proname,
+		pg_get_function_identity_arguments(p.oid), ` + granteeName + `)
+	FROM pg_proc p
+	JOIN pg_namespace n ON n.oid = p.pronamespace,
+	aclexplode(COALESCE(p.proacl, acldefault('f', p.proowner))) a
+	WHERE n.nspname = ANY($1::text[]) AND a.grantee <> p.proowner
+	UNION ALL
+	SELECT format('ALTER DEFAULT PRIVILEGES FOR ROLE %I%s GRANT %s ON %s TO %s;', pg_get_userbyid(d.defaclrole),
+		CASE WHEN d.defaclnamespace = 0 THEN '' ELSE format(' IN SCHEMA %I', n.nspname) END,
+		string_agg(a.privilege_type, ', ' ORDER BY a.privilege_type),
+		CASE d.defaclobjtype WHEN 'r' THEN 'TABLES' WHEN 'S' THEN 'SEQUENCES' WHEN 'f' THEN 'FUNCTIONS' WHEN 'T' THEN 'TYPES' ELSE 'SCHEMAS' END,
+		` + granteeName + `)
+	FROM pg_default_acl d
+	LEFT JOIN pg_namespace n ON n.oid = d.defaclnamespace,
+	aclexplode(d.defaclacl) a
+	WHERE d.defaclnamespace = 0 OR n.nspname = ANY($1::text[])
+	GROUP BY d.defaclrole, d.defaclnamespace, n.nspname, d.defaclobjtype, a.grantee
+`
+
+// Reads the grants of the exposed schemas, sorted
+func currentGrants(ctx context.Context, db *sql.DB, schemas []string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, grantsQuery, formatPostgresArray(schemas))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []string
+	for rows.Next() {
+		var g string
+		if err := rows.Scan(&g); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(grants)
+	return grants, nil
+}
+
+// Reads a grants file: one statement per line; -- comments and blank lines
+// are skipped
+func readGrantsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no grants file at %s; create it with roles dump", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var grants []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "--") {
+			grants = append(grants, line)
+		}
+	}
+	return grants, scanner.Err()
+}
+
+// Handles `roles dump`, which writes the role memberships and grants of the
+// exposed schemas to --grants-file, and `roles diff`, which compares the
+// database with that file. Returns false if diff found drift.
+func runRoles(opts options, dbURL string, args []string) (bool, error) {
+	if len(args) != 1 || (args[0] != "dump" && args[0] != "diff") {
+		return false, fmt.Errorf("usage: roles dump | roles diff")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	schemas, err := exposedSchemas(ctx, db, opts.exposedSchemas)
+	if err != nil {
+		return false, err
+	}
+	current, err := currentGrants(ctx, db, schemas)
+	if err != nil {
+		return false, err
+	}
+
+	if args[0] == "dump" {
+		var b strings.Builder
+		fmt.Fprintf(&b, "-- Role memberships and grants on %s, written by `roles dump`.\n", strings.Join(schemas, ", "))
+		b.WriteString("-- Compare with the database using `roles diff`.\n")
+		for _, g := range current {
+			b.WriteString(g + "\n")
+		}
+		if err := os.MkdirAll(filepath.Dir(opts.grantsFile), 0o755); err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(opts.grantsFile, []byte(b.String()), 0o644); err != nil {
+			return false, err
+		}
+		logInfo("Wrote %d grant(s) to %s.", len(current), opts.grantsFile)
+		return true, nil
+	}
+
+	recorded, err := readGrantsFile(opts.grantsFile)
+	if err != nil {
+		return false, err
+	}
+	want := map[string]bool{}
+	for _, g := range recorded {
+		want[g] = true
+	}
+	have := map[string]bool{}
+	for _, g := range current {
+		have[g] = true
+	}
+
+	drift := 0
+	for _, g := range recorded {
+		if !have[g] {
+			logInfo("- %s", g)
+			drift++
+		}
+	}
+	for _, g := range current {
+		if !want[g] {
+			logInfo("+ %s", g)
+			drift++
+		}
+	}
+	if drift > 0 {
+		logError("%d grant(s) differ from %s (- only in the file, + only in the database).", drift, opts.grantsFile)
+		return false, nil
+	}
+	logInfo("Grants match %s (%d).", opts.grantsFile, len(current))
+	return true, nil
+}
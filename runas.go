@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Switches the session to role after checking that it exists and that the
+// connecting role is a member of it, so a typo fails with a clear message
+// instead of running the migration as the wrong role
+func setRole(ctx context.Context, conn *sql.Conn, role string) error {
+	name := unquoteIdent(role)
+
+	var exists, member bool
+	err := conn.QueryRowContext(ctx, `
+		SELECT
+			EXISTS(SELECT 1 FROM pg_roles WHERE rolname = $1),
+			COALESCE((SELECT pg_has_role(oid, 'MEMBER') FROM pg_roles WHERE rolname = $1), false)
+	`, name).Scan(&exists, &member)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !exists:
+		return fmt.Errorf("-- run-as role %s does not exist", role)
+	case !member:
+		return fmt.Errorf("-- run-as: the connecting role is not a member of %s, so it can't SET ROLE to it", role)
+	}
+
+	if _, err := conn.ExecContext(ctx, `SET ROLE `+pgx.Identifier{name}.Sanitize()); err != nil {
+		return err
+	}
+	logInfo("Running as role %s.", name)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// An object a command names without a schema, so that where it is created or
+// which one it means depends on the connecting role's search_path
+type unqualifiedRef struct {
+	Kind string // e.g. "CREATE TABLE", "INSERT INTO"
+	Name string
+}
+
+// Object kinds whose CREATE, ALTER and DROP name a schema-scoped object,
+// multi-word kinds first
+var schemaScopedKinds = [][]string{
+	{"MATERIALIZED", "VIEW"},
+	{"TABLE"}, {"VIEW"}, {"SEQUENCE"}, {"FUNCTION"}, {"PROCEDURE"},
+	{"TYPE"}, {"DOMAIN"}, {"INDEX"},
+}
+
+// Returns the objects the commands of a statement create, change or write to
+// without naming their schema. Temporary objects, names of indexes being
+// created (they go in their table's schema) and anything inside function
+// bodies aren't reported.
+func unqualifiedRefs(stmt string) []unqualifiedRef {
+	var refs []unqualifiedRef
+	for _, cmd := range splitCommands(stmt) {
+		refs = append(refs, commandUnqualifiedRefs(cmd)...)
+	}
+	return refs
+}
+
+func commandUnqualifiedRefs(cmd sqlCommand) []unqualifiedRef {
+	var refs []unqualifiedRef
+	check := func(kind string, i int) int {
+		name, next := readQualifiedName(cmd, i)
+		if name != "" && !strings.Contains(name, ".") && (migrate.IsWordByte(name[0]) || name[0] == '"') {
+			refs = append(refs, unqualifiedRef{Kind: kind, Name: name})
+		}
+		return next
+	}
+	// A comma-separated list of names, as DROP and TRUNCATE take
+	checkList := func(kind string, i int) {
+		for i < len(cmd) {
+			i = check(kind, cmd.skip(i, "ONLY"))
+			if i >= len(cmd) || cmd[i] != "," {
+				return
+			}
+			i++
+		}
+	}
+	// Index of the object kind after CREATE [OR REPLACE] [modifiers], and the
+	// kind's words
+	kindAt := func(i int) (int, []string) {
+		for _, kind := range schemaScopedKinds {
+			if cmd.is(i, kind...) {
+				return i, kind
+			}
+		}
+		return -1, nil
+	}
+
+	switch {
+	case cmd.is(0, "CREATE"):
+		i := cmd.skip(1, "OR", "REPLACE", "UNIQUE", "UNLOGGED", "RECURSIVE")
+		if cmd.is(i, "TEMP") || cmd.is(i, "TEMPORARY") || cmd.is(i, "GLOBAL") || cmd.is(i, "LOCAL") {
+			return nil
+		}
+		switch {
+		case cmd.is(i, "INDEX"):
+			if on := cmd.find(i, "ON"); on >= 0 {
+				check("CREATE INDEX ON", cmd.skip(on+1, "ONLY"))
+			}
+		case cmd.is(i, "TRIGGER"), cmd.is(i, "CONSTRAINT", "TRIGGER"), cmd.is(i, "POLICY"):
+			if on := cmd.find(i, "ON"); on >= 0 {
+				kind := "CREATE TRIGGER ON"
+				if cmd.is(i, "POLICY") {
+					kind = "CREATE POLICY ON"
+				}
+				check(kind, on+1)
+			}
+		default:
+			if at, kind := kindAt(i); at >= 0 {
+				check("CREATE "+strings.Join(kind, " "), cmd.skip(at+len(kind), "IF", "NOT", "EXISTS"))
+			}
+		}
+	case cmd.is(0, "ALTER"):
+		if at, kind := kindAt(1); at >= 0 {
+			check("ALTER "+strings.Join(kind, " "), cmd.skip(at+len(kind), "IF", "EXISTS", "ONLY"))
+		}
+	case cmd.is(0, "DROP"):
+		if at, kind := kindAt(1); at >= 0 {
+			checkList("DROP "+strings.Join(kind, " "), cmd.skip(at+len(kind), "CONCURRENTLY", "IF", "EXISTS"))
+		}
+	case cmd.is(0, "INSERT", "INTO"):
+		check("INSERT INTO", 2)
+	case cmd.is(0, "UPDATE"):
+		check("UPDATE", cmd.skip(1, "ONLY"))
+	case cmd.is(0, "DELETE", "FROM"):
+		check("DELETE FROM", cmd.skip(2, "ONLY"))
+	case cmd.is(0, "TRUNCATE"):
+		checkList("TRUNCATE", cmd.skip(1, "TABLE"))
+	}
+	return refs
+}
+
+// Reports whether a migration sets search_path itself, making the names it
+// doesn't qualify unambiguous
+func setsSearchPath(m Migration) bool {
+	for _, stmt := range m.Statements {
+		for _, cmd := range splitCommands(stmt) {
+			if cmd.is(0, "SET", "SEARCH_PATH") || cmd.is(0, "SET", "LOCAL", "SEARCH_PATH") || cmd.is(0, "SET", "SESSION", "SEARCH_PATH") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns one message per unqualified reference in the migrations, with the
+// name qualified with schema as the suggested fix
+func checkQualifiedNames(migrations []Migration, schema string) []string {
+	var problems []string
+	for _, m := range migrations {
+		if setsSearchPath(m) {
+			continue
+		}
+		for i, stmt := range m.Statements {
+			for _, ref := range unqualifiedRefs(stmt) {
+				where := fmt.Sprintf("%s_%s", m.Version, m.Name)
+				if len(m.Statements) > 1 {
+					where += fmt.Sprintf(": statement %d", i+1)
+				}
+				problems = append(problems, fmt.Sprintf("%s: %s %s relies on search_path; write %s %s.%s",
+					where, ref.Kind, ref.Name, ref.Kind, quoteIdentIfNeeded(schema), ref.Name))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Seed file used when neither --seed-files nor config.toml names any, as
+// with `supabase db reset`
+const defaultSeedFile = "./supabase/seed.sql"
+
+// Seed files that ran, by path, in the table the Supabase CLI uses for
+// `db push --include-seed`
+const seedTableName = "seed_files"
+
+// A seed file to run
+type seedFile struct {
+	Path       string
+	Statements []string
+	Hash       string
+	// Run on every seeding, for seeds written to be idempotent
+	Always bool
+}
+
+// Picks the seed file patterns: --seed-files, then sql_paths under [db.seed]
+// in supabase/config.toml (relative to the supabase directory), then
+// ./supabase/seed.sql
+func resolveSeedPatterns(flagValue string) []string {
+	if flagValue != "" {
+		var patterns []string
+		for _, p := range strings.Split(flagValue, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+		return patterns
+	}
+	if value, ok := configValue(supabaseConfigFile, "db.seed", "sql_paths"); ok {
+		var patterns []string
+		for _, p := range parseTOMLStrings(value) {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(filepath.Dir(supabaseConfigFile), p)
+			}
+			patterns = append(patterns, p)
+		}
+		return patterns
+	}
+	return []string{defaultSeedFile}
+}
+
+// Returns the strings of a single-line TOML array, e.g. ['./seed.sql', "./seeds/*.sql"]
+func parseTOMLStrings(value string) []string {
+	open, end := strings.IndexByte(value, '['), strings.LastIndexByte(value, ']')
+	if open < 0 || end < open {
+		return nil
+	}
+	var values []string
+	rest := value[open+1 : end]
+	for {
+		i := strings.IndexAny(rest, `"'`)
+		if i < 0 {
+			return values
+		}
+		s, ok := unquoteTOML(rest[i:])
+		if !ok {
+			return values
+		}
+		values = append(values, s)
+		rest = rest[i+len(s)+2:]
+	}
+}
+
+// Expands the patterns into seed files, in pattern order and by name within a
+// pattern, each file once. Patterns matching nothing are skipped, so the
+// default seed.sql is optional.
+func loadSeedFiles(patterns []string) ([]seedFile, error) {
+	seen := map[string]bool{}
+	var seeds []seedFile
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("seed pattern %s: %v", pattern, err)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			expanded, err := expandPsqlVariables(string(raw))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			directives, err := parseDirectives(string(raw))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			seeds = append(seeds, seedFile{
+				Path:       filepath.ToSlash(filepath.Clean(path)),
+				Statements: migrate.SplitStatements(expanded),
+				Hash:       migrationHash(string(raw)),
+				Always:     directives.RunAlways,
+			})
+		}
+	}
+	return seeds, nil
+}
+
+func ensureSeedTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			path text NOT NULL PRIMARY KEY,
+			hash text NOT NULL
+		)
+	`, schemaName, seedTableName))
+	return err
+}
+
+// Runs the seed files that haven't run on this database yet, each in its own
+// transaction that also records it. Seeds with -- run-always run every time;
+// with force, every seed runs again. A seed edited since it ran is only
+// reported, since running it twice would usually insert its rows twice.
+func runSeeds(ctx context.Context, db *sql.DB, patterns []string, force bool) error {
+	seeds, err := loadSeedFiles(patterns)
+	if err != nil {
+		return err
+	}
+	if len(seeds) == 0 {
+		logDebug("No seed files match %s.", strings.Join(patterns, ", "))
+		return nil
+	}
+	if err := ensureSeedTable(ctx, db); err != nil {
+		return fmt.Errorf("error creating seed table: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT path, hash FROM %s.%s`, schemaName, seedTableName))
+	if err != nil {
+		return err
+	}
+	seeded := map[string]string{}
+	for rows.Next() {
+		var path, hash string
+		if err := rows.Scan(&path, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		seeded[path] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ran := 0
+	for _, s := range seeds {
+		hash, done := seeded[s.Path]
+		if done && !force && !s.Always {
+			if hash != s.Hash {
+				logWarn("Warning: seed %s changed since it ran; it was not run again (use `seed --force` to re-run seeds)", s.Path)
+			}
+			continue
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for i, stmt := range s.Statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("seed %s, statement %d: %v", s.Path, i+1, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s.%s (path, hash) VALUES ($1, $2)
+			ON CONFLICT (path) DO UPDATE SET hash = EXCLUDED.hash
+		`, schemaName, seedTableName), s.Path, s.Hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		logEvent(levelInfo, "seed_applied", logFields{"path": s.Path, "statements": len(s.Statements)}, "Seeded %s.", s.Path)
+		ran++
+	}
+	if ran == 0 {
+		logInfo("Seeds are up to date.")
+	}
+	return nil
+}
+
+// Handles the `seed` command: runs the seed files without applying
+// migrations
+func runSeed(opts options, dbURL string) error {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := connectWithRetry(ctx, db, opts.connectRetries, opts.retryInterval); err != nil {
+		return err
+	}
+	if err := migrate.EnsureTable(ctx, db); err != nil {
+		return err
+	}
+	if err := ensureLockTable(ctx, db); err != nil {
+		return err
+	}
+	lock, err := acquireMigrationLock(ctx, db, opts)
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer lock.release(ctx)
+
+	return runSeeds(ctx, db, resolveSeedPatterns(opts.seedFiles), opts.force)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Returns the local migrations up to and including version, or all of them
+// when version is empty
+func migrationsUpTo(migrations []Migration, version string) ([]Migration, error) {
+	if version == "" {
+		return migrations, nil
+	}
+	for i, m := range migrations {
+		if m.Version == version {
+			return migrations[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("no local migration with version %s", version)
+}
+
+// Prints the schema as of a version: the migrations up to it concatenated
+// into one document, or with --scratch the objects they create when applied
+// to the database in DATABASE_URL inside a transaction that is rolled back
+func runShow(opts options) error {
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return err
+	}
+	upTo, err := migrationsUpTo(migrations, opts.at)
+	if err != nil {
+		return err
+	}
+	if len(upTo) == 0 {
+		return fmt.Errorf("no local migrations")
+	}
+	last := upTo[len(upTo)-1]
+
+	if opts.scratch {
+		return showScratch(requireDatabaseURL(), upTo)
+	}
+
+	fmt.Printf("-- Schema at %s (%s): %d migration(s)\n", last.Version, last.Name, len(upTo))
+	for _, m := range upTo {
+		if m.Directives.RunAlways {
+			continue
+		}
+		fmt.Printf("\n-- %s_%s\n", m.Version, m.Name)
+		for _, stmt := range m.Statements {
+			stmt = strings.TrimSpace(stmt)
+			if !strings.HasSuffix(stmt, ";") {
+				stmt += ";"
+			}
+			fmt.Println(stmt)
+		}
+	}
+	return nil
+}
+
+// Applies the migrations in a transaction, prints every object that exists
+// afterwards but didn't before, and rolls back
+func showScratch(dbURL string, migrations []Migration) error {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", withApplicationName(dbURL))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := schemaObjectList(ctx, tx)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, def := range before {
+		existing[def] = true
+	}
+
+	for _, m := range migrations {
+		if m.Directives.RunAlways {
+			continue
+		}
+		if m.Directives.NoTransaction {
+			return fmt.Errorf("migration %s is -- no-transaction and can't run in the scratch transaction; run show without --scratch", m.Version)
+		}
+		for i, stmt := range m.Statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("migration %s statement %d: %v", m.Version, i+1, err)
+			}
+		}
+	}
+
+	after, err := schemaObjectList(ctx, tx)
+	if err != nil {
+		return err
+	}
+	last := migrations[len(migrations)-1]
+	fmt.Printf("-- Objects created by migrations up to %s (%s)\n", last.Version, last.Name)
+	schema := ""
+	for _, def := range after {
+		if existing[def] {
+			continue
+		}
+		s, d, _ := strings.Cut(def, "\t")
+		if s != schema {
+			schema = s
+			fmt.Printf("\n-- schema %s\n", schema)
+		}
+		fmt.Println(d)
+	}
+	logInfo("Rolled back; nothing was changed.")
+	return nil
+}
+
+// Returns "schema<TAB>definition" for every object, sorted
+func schemaObjectList(ctx context.Context, ex execer) ([]string, error) {
+	rows, err := ex.QueryContext(ctx, `SELECT nspname || E'\t' || def FROM (`+schemaObjectListQuery+`) objects ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var defs []string
+	for rows.Next() {
+		var def string
+		if err := rows.Scan(&def); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, rows.Err()
+}
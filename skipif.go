@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Evaluates a migration's -- skip-if guard, a query returning one boolean.
+// Migrations without a guard never skip.
+func (r *applyRun) skipIfHolds(ctx context.Context, m Migration) (bool, error) {
+	if m.Directives.SkipIf == "" {
+		return false, nil
+	}
+	var skip bool
+	if err := r.db.QueryRowContext(ctx, m.Directives.SkipIf).Scan(&skip); err != nil {
+		return false, fmt.Errorf("evaluating -- skip-if of %s: %v", m.Version, err)
+	}
+	return skip, nil
+}
+
+// Records a migration whose guard held as applied without statements, with
+// the guard as the reason, so it doesn't come up as pending again
+func (r *applyRun) recordSkipped(ctx context.Context, m Migration) error {
+	_, err := r.db.ExecContext(ctx,
+		fmt.Sprintf(`
+			INSERT INTO %s.%s
+				(version, name, hash, statements, created_by, idempotency_key, statement_count, skipped_reason, out_of_order_after)
+			VALUES
+				($1, $2, $3, '{}', $4, NULL, 0, $5, NULLIF($6, ''))
+		`, schemaName, tableName),
+		m.Version,
+		m.Name,
+		m.Hash,
+		"supabase-direct-migrate",
+		"skip-if: "+m.Directives.SkipIf,
+		r.outOfOrder[m.Version],
+	)
+	return err
+}
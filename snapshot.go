@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// The runner holding the migration lock
+type activeRunner struct {
+	Holder  string
+	Version string // migration it is applying, if it reported one
+	Since   time.Time
+}
+
+func (a *activeRunner) Error() string {
+	msg := fmt.Sprintf("another runner is active: %s, since %s", a.Holder, a.Since.Format(time.RFC3339))
+	if a.Version != "" {
+		msg += ", applying " + a.Version
+	}
+	return msg + "; the pending migrations are changing, run again when it finishes"
+}
+
+// The history and the migration lock as of one moment
+type historySnapshot struct {
+	Applied   map[string]string    // version -> hash
+	AppliedAt map[string]time.Time // version -> created_at; archived versions get their baseline's
+	Runner    *activeRunner        // nil unless a runner holds an unexpired lease
+}
+
+// Reads the history and the migration lock in one read-only REPEATABLE READ
+// snapshot, so they agree with each other even while another runner commits
+// migrations
+func readHistorySnapshot(ctx context.Context, db *sql.DB) (historySnapshot, error) {
+	var snap historySnapshot
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return snap, err
+	}
+	defer tx.Rollback()
+
+	if snap.Applied, err = fetchAppliedMigrations(ctx, tx); err != nil {
+		return snap, err
+	}
+	if snap.AppliedAt, err = fetchAppliedTimes(ctx, tx); err != nil {
+		return snap, err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, schemaName+"."+lockTableName).Scan(&exists)
+	if err != nil || !exists {
+		return snap, err
+	}
+	// current_version is read through to_jsonb as lock tables from before
+	// heartbeats don't have it
+	var runner activeRunner
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT holder, COALESCE(to_jsonb(l)->>'current_version', ''), acquired_at
+		FROM %s.%s l WHERE id = 1 AND expires_at > NOW()
+	`, schemaName, lockTableName)).Scan(&runner.Holder, &runner.Version, &runner.Since)
+	if err == sql.ErrNoRows {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+	snap.Runner = &runner
+	return snap, nil
+}
+
+// Fetches version -> time each applied migration was recorded
+func fetchAppliedTimes(ctx context.Context, ex execer) (map[string]time.Time, error) {
+	var exists bool
+	err := ex.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, schemaName+"."+tableName).Scan(&exists)
+	if err != nil || !exists {
+		return map[string]time.Time{}, err
+	}
+	rows, err := ex.QueryContext(ctx, fmt.Sprintf(`
+		SELECT version, created_at, CASE WHEN created_by = $1 THEN array_to_string(statements, E'\n') END
+		FROM %s.%s
+	`, schemaName, tableName), baselineCreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := map[string]time.Time{}
+	for rows.Next() {
+		var version string
+		var at sql.NullTime
+		var baseline sql.NullString
+		if err := rows.Scan(&version, &at, &baseline); err != nil {
+			return nil, err
+		}
+		if !at.Valid {
+			continue
+		}
+		if baseline.Valid {
+			for v := range migrate.ParseBaseline(baseline.String) {
+				times[v] = at.Time
+			}
+			continue
+		}
+		times[version] = at.Time
+	}
+	return times, rows.Err()
+}
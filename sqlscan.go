@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+)
+
+// Reports whether tok is the given keyword, case-insensitively. Quoted
+// identifiers never match.
+func isKeyword(tok, keyword string) bool {
+	return strings.EqualFold(tok, keyword)
+}
+
+// Reads a possibly schema-qualified name starting at tokens[i]. Returns the
+// name as written (e.g. public."Orders") and the index after it.
+func readQualifiedName(tokens []string, i int) (string, int) {
+	if i >= len(tokens) {
+		return "", i
+	}
+	name := tokens[i]
+	i++
+	for i+1 < len(tokens) && tokens[i] == "." {
+		name += "." + tokens[i+1]
+		i += 2
+	}
+	return name, i
+}
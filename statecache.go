@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Last-known applied migrations of a database, so status can answer offline
+type stateCache struct {
+	Target      string            `json:"target"` // host:port/database, without credentials
+	Fingerprint string            `json:"fingerprint"`
+	FetchedAt   time.Time         `json:"fetched_at"`
+	Applied     map[string]string `json:"applied"` // version -> hash
+}
+
+// Identifies the database a URL points at, without credentials
+func stateTarget(dbURL string) string {
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host + u.Path
+}
+
+// Hash of the applied versions and their hashes, to tell whether the history
+// changed since the cache was written
+func historyFingerprint(applied map[string]string) string {
+	versions := make([]string, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	h := sha256.New()
+	for _, v := range versions {
+		h.Write([]byte(v + ":" + applied[v] + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Records the applied set fetched from the database. Failures only matter to
+// later offline runs, so they are logged and otherwise ignored.
+func saveStateCache(path, dbURL string, applied map[string]string) {
+	if path == "" {
+		return
+	}
+	fingerprint := historyFingerprint(applied)
+	if previous, err := readStateCache(path); err == nil && previous.Target == stateTarget(dbURL) && previous.Fingerprint != fingerprint {
+		logDebug("History of %s changed since %s; refreshing %s.", previous.Target, previous.FetchedAt.Format(time.RFC3339), path)
+	}
+
+	raw, err := json.MarshalIndent(stateCache{
+		Target:      stateTarget(dbURL),
+		Fingerprint: fingerprint,
+		FetchedAt:   time.Now().UTC(),
+		Applied:     applied,
+	}, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			err = os.WriteFile(path, raw, 0o644)
+		}
+	}
+	if err != nil {
+		logWarn("Warning: could not write state cache %s: %v", path, err)
+	}
+}
+
+func readStateCache(path string) (stateCache, error) {
+	var c stateCache
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(raw, &c)
+	return c, err
+}
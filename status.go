@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Prints every migration with its status, hash and when it was applied, and
+// reports whether nothing is pending. Online it asks the database (refreshing
+// --state-cache); with --offline it answers from the state cache instead,
+// which doesn't know when migrations were applied. --since-tag limits it to
+// the versions after a tag.
+func runStatus(opts options) (bool, error) {
+	var applied map[string]string
+	appliedAt := map[string]time.Time{}
+	var asOf string
+	var since string // only versions after this one, from --since-tag
+
+	if opts.offline {
+		if opts.sinceTag != "" {
+			return false, fmt.Errorf("status --since-tag needs the database; it can't be used with --offline")
+		}
+		if opts.stateCache == "" {
+			return false, fmt.Errorf("status --offline needs --state-cache")
+		}
+		cache, err := readStateCache(opts.stateCache)
+		if err != nil {
+			return false, fmt.Errorf("no usable state cache at %s (run status online once first): %v", opts.stateCache, err)
+		}
+		if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" && stateTarget(dbURL) != cache.Target {
+			logWarn("Warning: state cache is for %s, not %s", cache.Target, stateTarget(dbURL))
+		}
+		applied = cache.Applied
+		asOf = fmt.Sprintf(" (offline, as of %s, %s ago)",
+			cache.FetchedAt.Format(time.RFC3339), time.Since(cache.FetchedAt).Round(time.Minute))
+	} else {
+		dbURL := requireDatabaseURL()
+		db, err := sql.Open("pgx", dbURL)
+		if err != nil {
+			return false, err
+		}
+		defer db.Close()
+
+		snap, err := readHistorySnapshot(context.Background(), db)
+		if err != nil {
+			return false, err
+		}
+		if snap.Runner != nil {
+			return false, snap.Runner
+		}
+		applied, appliedAt = snap.Applied, snap.AppliedAt
+		saveStateCache(opts.stateCache, dbURL, applied)
+
+		if opts.sinceTag != "" {
+			if since, err = tagVersion(context.Background(), db, opts.sinceTag); err != nil {
+				return false, err
+			}
+			asOf = fmt.Sprintf(" since %s (%s)", opts.sinceTag, since)
+		}
+	}
+
+	migrations, err := loadLocalMigrationsCached(opts.manifestCache, applied)
+	if err != nil {
+		return false, err
+	}
+	localHashes := map[string]string{}
+	for _, m := range migrations {
+		localHashes[m.Version] = m.Hash
+	}
+
+	counts := map[string]int{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tHASH\tAPPLIED AT\tNAME")
+	for _, e := range listEntries(migrations, applied) {
+		if since != "" && e.Version <= since {
+			continue
+		}
+		counts[e.Status]++
+
+		hash := shortHash(applied[e.Version])
+		switch e.Status {
+		case "pending", "run-always":
+			hash = shortHash(localHashes[e.Version])
+		case "hash-mismatch":
+			hash = shortHash(localHashes[e.Version]) + " (applied " + hash + ")"
+		}
+		at := "-"
+		if t, ok := appliedAt[e.Version]; ok {
+			at = t.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Version, e.Status, hash, at, e.Name)
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+
+	fmt.Println()
+	if counts["pending"] == 0 {
+		logInfo("Up to date: no pending migrations%s.", asOf)
+	} else {
+		logInfo("%d pending migration(s)%s.", counts["pending"], asOf)
+	}
+	if n := counts["missing"]; n > 0 {
+		logWarn("Warning: %d applied migration(s) have no local file", n)
+	}
+	if n := counts["hash-mismatch"]; n > 0 {
+		logWarn("Warning: %d migration(s) changed after they were applied", n)
+	}
+	return counts["pending"] == 0, nil
+}
+
+// Abbreviates a hash for tables
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	if hash == "" {
+		return "-"
+	}
+	return hash
+}
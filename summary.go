@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Longest-running statement of a migration
+type slowStatement struct {
+	n int // 1-based
+	d time.Duration
+}
+
+// Timing of one migration of the run, for the summary
+type migrationTiming struct {
+	Version    string
+	Name       string
+	Statements int
+	Duration   time.Duration
+	Slowest    slowStatement
+}
+
+// Remembers how long a statement took, keeping the slowest per migration.
+// Parallel blocks call this from several goroutines.
+func (r *applyRun) noteStatement(version string, n int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.slowest == nil {
+		r.slowest = map[string]slowStatement{}
+	}
+	if d > r.slowest[version].d {
+		r.slowest[version] = slowStatement{n: n, d: d}
+	}
+}
+
+// Timing of a migration that just finished in d
+func (r *applyRun) timing(m Migration, d time.Duration) migrationTiming {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return migrationTiming{
+		Version:    m.Version,
+		Name:       m.Name,
+		Statements: len(m.Statements),
+		Duration:   d,
+		Slowest:    r.slowest[m.Version],
+	}
+}
+
+// Logs a table of the migrations the run applied with their statement count,
+// duration and slowest statement, then the total elapsed time. The slowest
+// statement of the run is marked with *, since that is where a production
+// deploy would hold its locks longest.
+func printRunSummary(timings []migrationTiming, total time.Duration) {
+	if len(timings) == 0 {
+		return
+	}
+	slowest := 0
+	for i, t := range timings {
+		if t.Slowest.d > timings[slowest].Slowest.d {
+			slowest = i
+		}
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATEMENTS\tDURATION\tSLOWEST STATEMENT")
+	for i, t := range timings {
+		slow := "-"
+		if t.Slowest.n > 0 {
+			slow = fmt.Sprintf("#%d %s", t.Slowest.n, roundDuration(t.Slowest.d))
+			if i == slowest {
+				slow += " *"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", t.Version, t.Name, t.Statements, roundDuration(t.Duration), slow)
+	}
+	w.Flush()
+
+	logInfo("")
+	logInfo("%s", strings.TrimRight(b.String(), "\n"))
+	logInfo("Total: %d migration(s) in %s.", len(timings), roundDuration(total))
+}
+
+// Rounds a duration for display: milliseconds under a minute, seconds above
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "fmt"
+
+func openSyslogSink(tag string) (logSink, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
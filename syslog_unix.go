@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+package main
+
+import "log/syslog"
+
+// Forwards log lines to the local syslog daemon. On systemd hosts journald
+// owns /dev/log, so the same sink feeds the journal with matching priorities.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func openSyslogSink(tag string) (logSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) write(level logLevel, msg string) {
+	switch level {
+	case levelDebug:
+		s.w.Debug(msg)
+	case levelWarn:
+		s.w.Warning(msg)
+	case levelError:
+		s.w.Err(msg)
+	default:
+		s.w.Info(msg)
+	}
+}
+
+func (s *syslogSink) close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const tagsTableName = "schema_migrations_tags"
+
+// Creates the tags table if it doesn't exist. A tag names the latest applied
+// version at a release boundary.
+func ensureTagsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			name TEXT PRIMARY KEY,
+			version TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			created_by TEXT
+		)
+	`, schemaName, tagsTableName))
+	return err
+}
+
+// Returns the version a tag points at
+func tagVersion(ctx context.Context, db *sql.DB, name string) (string, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, schemaName+"."+tagsTableName).Scan(&exists); err != nil {
+		return "", err
+	}
+	var version string
+	err := sql.ErrNoRows
+	if exists {
+		err = db.QueryRowContext(ctx,
+			fmt.Sprintf(`SELECT version FROM %s.%s WHERE name = $1`, schemaName, tagsTableName), name,
+		).Scan(&version)
+	}
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no tag named %s", name)
+	}
+	return version, err
+}
+
+// Handles `tag NAME`, which tags the latest applied version, and `tag`, which
+// lists the tags
+func runTag(dbURL string, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("tag takes at most one name")
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := ensureTagsTable(ctx, db); err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return listTags(ctx, db)
+	}
+	name := args[0]
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	latest := ""
+	for v := range applied {
+		latest = max(latest, v)
+	}
+	if latest == "" {
+		return fmt.Errorf("nothing is applied yet, so there is nothing to tag")
+	}
+
+	res, err := db.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s.%s (name, version, created_by) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING
+	`, schemaName, tagsTableName), name, latest, applicationName())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		version, _ := tagVersion(ctx, db, name)
+		return fmt.Errorf("tag %s already exists (at %s)", name, version)
+	}
+	logInfo("Tagged %s as %s.", latest, name)
+	return nil
+}
+
+// Prints the tags, oldest first
+func listTags(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT name, version, created_at FROM %s.%s ORDER BY created_at, name
+	`, schemaName, tagsTableName))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tVERSION\tCREATED AT")
+	n := 0
+	for rows.Next() {
+		var name, version string
+		var createdAt time.Time
+		if err := rows.Scan(&name, &version, &createdAt); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, version, createdAt.Local().Format("2006-01-02 15:04:05"))
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if n == 0 {
+		logInfo("No tags yet; tag the history with `tag NAME`.")
+		return nil
+	}
+	return w.Flush()
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Team templates in this directory override the built-in ones by name
+const templatesDir = "./supabase/templates"
+
+// Template in templatesDir put at the top of every new migration, e.g. a
+// comment with the author and ticket. Names starting with _ aren't listed as
+// templates.
+const headerTemplate = "_header"
+
+// Commented example of statement breakpoints, for `new --with-examples`
+const breakpointExample = `-- Statements are split at top-level semicolons. Where that isn't enough,
+-- put -- statement-breakpoint lines between statements; the file is then
+-- split at those lines only. For example:
+--
+-- create table public.example (id bigint primary key);
+-- -- statement-breakpoint
+-- create index example_created_idx on public.example (id);
+`
+
+// Down section for `new --with-down`; `down` runs it to revert the migration
+const downSectionStub = `-- down
+-- Statements reverting the migration, newest change first.
+`
+
+// Built-in templates for `new --template`
+var builtinTemplates = map[string]string{
+	"create-table": `create table {{ident .Schema .Name}} (
+  id bigint generated always as identity primary key,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+alter table {{ident .Schema .Name}} enable row level security;
+`,
+	// Keeps updated_at current on every update. The function is shared by all
+	// tables of the schema, so it is created or replaced each time.
+	"updated-at": `create or replace function {{ident .Schema "set_updated_at"}}()
+returns trigger
+language plpgsql
+as $$
+begin
+  new.updated_at = now();
+  return new;
+end;
+$$;
+
+create trigger set_updated_at
+  before update on {{ident .Schema .Name}}
+  for each row
+  execute function {{ident .Schema "set_updated_at"}}();
+`,
+	// Range-partitioned by created_at, with monthly partitions kept three
+	// months ahead by the -- partitions directive and `partitions ensure`
+	"partitioned-table": `-- partitions: {{.Schema}}.{{.Name}} monthly 3
+create table {{ident .Schema .Name}} (
+  id bigint generated always as identity,
+  created_at timestamptz not null default now(),
+  primary key (id, created_at)
+) partition by range (created_at);
+
+alter table {{ident .Schema .Name}} enable row level security;
+`,
+	// Each user can only see and change their own rows. auth.uid() is wrapped
+	// in a subquery so it is evaluated once per statement, not once per row.
+	"policy-owner-crud": `alter table {{ident .Schema .Name}} enable row level security;
+
+create policy {{ident (printf "Users can view their own %s" .Name)}} on {{ident .Schema .Name}}
+  for select to authenticated
+  using ((select auth.uid()) = {{ident .OwnerColumn}});
+
+create policy {{ident (printf "Users can create their own %s" .Name)}} on {{ident .Schema .Name}}
+  for insert to authenticated
+  with check ((select auth.uid()) = {{ident .OwnerColumn}});
+
+create policy {{ident (printf "Users can update their own %s" .Name)}} on {{ident .Schema .Name}}
+  for update to authenticated
+  using ((select auth.uid()) = {{ident .OwnerColumn}})
+  with check ((select auth.uid()) = {{ident .OwnerColumn}});
+
+create policy {{ident (printf "Users can delete their own %s" .Name)}} on {{ident .Schema .Name}}
+  for delete to authenticated
+  using ((select auth.uid()) = {{ident .OwnerColumn}});
+`,
+	// Everyone, signed in or not, can read; writes stay closed
+	"policy-public-read": `alter table {{ident .Schema .Name}} enable row level security;
+
+create policy {{ident (printf "%s are viewable by everyone" .Name)}} on {{ident .Schema .Name}}
+  for select to anon, authenticated
+  using (true);
+`,
+}
+
+// Policy patterns for `new policy TABLE --policy PATTERN`, each backed by the
+// policy-PATTERN template
+var policyPatterns = []string{"owner-crud", "public-read"}
+
+// Values a template can use
+type templateData struct {
+	Name    string // --name, e.g. the table name
+	Schema  string // --schema
+	Version string // the new migration's version
+	// Column holding the owning user's id, for policy templates
+	OwnerColumn string
+}
+
+// Functions available in templates
+var templateFuncs = template.FuncMap{
+	// Quotes and joins identifier parts: {{ident .Schema .Name}} -> public.users
+	"ident": func(parts ...string) string {
+		var quoted []string
+		for _, p := range parts {
+			quoted = append(quoted, quoteIdentIfNeeded(p))
+		}
+		return strings.Join(quoted, ".")
+	},
+	// Quotes a string literal: {{literal .Name}} -> 'users'
+	"literal": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+}
+
+// Reserved words that are common table or column names
+var reservedIdents = map[string]bool{
+	"all": true, "analyze": true, "array": true, "case": true, "check": true,
+	"column": true, "constraint": true, "default": true, "desc": true, "do": true,
+	"end": true, "from": true, "grant": true, "group": true, "limit": true,
+	"offset": true, "order": true, "references": true, "select": true, "table": true,
+	"to": true, "user": true, "when": true, "where": true, "window": true, "with": true,
+}
+
+// Quotes an identifier only when PostgreSQL would fold or reject it unquoted,
+// so generated SQL reads like hand-written SQL
+func quoteIdentIfNeeded(name string) string {
+	if name == "" || reservedIdents[name] {
+		return pgx.Identifier{name}.Sanitize()
+	}
+	for i, c := range name {
+		lowerOrUnderscore := c == '_' || (c >= 'a' && c <= 'z')
+		if !lowerOrUnderscore && !(i > 0 && (c >= '0' && c <= '9' || c == '$')) {
+			return pgx.Identifier{name}.Sanitize()
+		}
+	}
+	return name
+}
+
+// Returns the source of a template: supabase/templates/{name}.sql, or else a
+// built-in one
+func lookupTemplate(name string) (string, error) {
+	raw, err := os.ReadFile(filepath.Join(templatesDir, name+".sql"))
+	if err == nil {
+		return string(raw), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if src, ok := builtinTemplates[name]; ok {
+		return src, nil
+	}
+	return "", fmt.Errorf("unknown template %q (available: %s)", name, strings.Join(templateNames(), ", "))
+}
+
+// Lists built-in and team template names
+func templateNames() []string {
+	seen := map[string]bool{}
+	for name := range builtinTemplates {
+		seen[name] = true
+	}
+	if files, err := filepath.Glob(filepath.Join(templatesDir, "*.sql")); err == nil {
+		for _, f := range files {
+			if name := strings.TrimSuffix(filepath.Base(f), ".sql"); !strings.HasPrefix(name, "_") {
+				seen[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Renders supabase/templates/_header.sql, or "" when there isn't one
+func renderHeader(data templateData) (string, error) {
+	if _, err := os.Stat(filepath.Join(templatesDir, headerTemplate+".sql")); os.IsNotExist(err) {
+		return "", nil
+	}
+	return renderTemplate(headerTemplate, data)
+}
+
+// Renders a template with its placeholders substituted
+func renderTemplate(name string, data templateData) (string, error) {
+	src, err := lookupTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %v", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template %s: %v", name, err)
+	}
+	return b.String(), nil
+}
+
+// Values a -- template migration can use
+type migrationTemplateData struct {
+	Version string
+	Name    string // the file name after the version, e.g. add_policies.sql
+}
+
+// Functions available in -- template migrations, on top of templateFuncs
+var migrationTemplateFuncs = template.FuncMap{
+	// Value of an environment variable; unset ones fail the render
+	"env": func(name string) (string, error) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	},
+	// Hex SHA-1 of a string, e.g. for short stable object names
+	"sha1": func(s string) string {
+		sum := sha1.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	},
+	// Splits a list to range over: {{range split "todos,notes" ","}}
+	"split": func(s, sep string) []string {
+		var parts []string
+		for _, p := range strings.Split(s, sep) {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+		return parts
+	},
+}
+
+// Reports whether a migration has the -- template directive. Checked before
+// the migration is parsed, since the template must be rendered first.
+func isTemplateMigration(raw string) bool {
+	for _, line := range strings.Split(raw, "\n") {
+		if match := directiveLine.FindStringSubmatch(strings.TrimSpace(line)); match != nil && match[1] == "template" {
+			return true
+		}
+	}
+	return false
+}
+
+// Renders a -- template migration with text/template
+func renderMigrationTemplate(filename, version, name, raw string) (string, error) {
+	tmpl, err := template.New(filename).Funcs(templateFuncs).Funcs(migrationTemplateFuncs).Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, migrationTemplateData{Version: version, Name: name}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTemplateMigrationHashIsStableAcrossRenders(t *testing.T) {
+	const filename = "20240101120000_stamp.sql"
+	raw := "-- template\nCOMMENT ON TABLE public.t IS {{literal now}};\n"
+
+	first, err := parseMigration(filename, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// now has second precision
+	time.Sleep(1100 * time.Millisecond)
+	second, err := parseMigration(filename, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Raw == second.Raw {
+		t.Fatalf("expected the two renders to differ, both are %q", first.Raw)
+	}
+	applied := map[string]string{first.Version: first.Hash}
+	if changed := changedSinceApplied([]Migration{second}, applied); len(changed) > 0 {
+		t.Errorf("verify reports %s as changed after re-rendering", changed[0].Version)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Which sessions blocking a migration may be terminated, and how long they
+// may block it first
+type terminatePolicy struct {
+	States []string // pg_stat_activity states, e.g. "idle in transaction"
+	After  time.Duration
+}
+
+func (p *terminatePolicy) String() string {
+	return fmt.Sprintf("%s after %s", strings.Join(p.States, ", "), p.After)
+}
+
+// --terminate-blockers state names, for the pg_stat_activity states they
+// stand for. Active sessions are deliberately not terminable.
+var terminableStates = map[string]string{
+	"idle_in_transaction":         "idle in transaction",
+	"idle_in_transaction_aborted": "idle in transaction (aborted)",
+}
+
+// Parses "idle_in_transaction,30s": the states, then how long a session in
+// one of them may block the migration
+func parseTerminateBlockers(s string) (*terminatePolicy, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("expected STATE[,STATE...],DURATION, e.g. idle_in_transaction,30s, got %q", s)
+	}
+	after, err := time.ParseDuration(strings.TrimSpace(parts[len(parts)-1]))
+	if err != nil || after < 0 {
+		return nil, fmt.Errorf("invalid duration %q", parts[len(parts)-1])
+	}
+	p := &terminatePolicy{After: after}
+	for _, name := range parts[:len(parts)-1] {
+		state, ok := terminableStates[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown session state %q (expected idle_in_transaction or idle_in_transaction_aborted)", strings.TrimSpace(name))
+		}
+		p.States = append(p.States, state)
+	}
+	return p, nil
+}
+
+// Watches the sessions blocking backend pid while a statement runs and
+// terminates the ones in a state the policy allows once they have blocked it
+// for p.After, logging each. Runs on a pooled connection; the returned
+// function stops it.
+func startBlockerTerminator(ctx context.Context, db *sql.DB, p *terminatePolicy, version string, pid int) func() {
+	if p == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		// When each blocker was first seen blocking, by pid
+		since := map[int]time.Time{}
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			blockers, err := blockingSessions(ctx, db, pid)
+			if err != nil {
+				logWarn("Warning: could not look up sessions blocking %s: %v", version, err)
+				continue
+			}
+			current := map[int]time.Time{}
+			for _, b := range blockers {
+				first, ok := since[b.PID]
+				if !ok {
+					first = time.Now()
+				}
+				current[b.PID] = first
+				if time.Since(first) < p.After || !slices.Contains(p.States, b.State) {
+					continue
+				}
+				var terminated bool
+				if err := db.QueryRowContext(ctx, `SELECT pg_terminate_backend($1)`, b.PID).Scan(&terminated); err != nil || !terminated {
+					logWarn("Warning: could not terminate pid %d blocking %s: %v", b.PID, version, err)
+					continue
+				}
+				delete(current, b.PID)
+				logEvent(levelWarn, "blocker_terminated", logFields{
+					"version": version, "pid": b.PID, "application": b.App, "state": b.State,
+					"transaction_ms": b.Duration.Milliseconds(), "query": b.Query,
+				}, "Terminated pid %d blocking %s (application %q, %s, transaction open for %s): %s",
+					b.PID, version, b.App, b.State, b.Duration.Round(time.Second), strings.Join(strings.Fields(b.Query), " "))
+			}
+			since = current
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Lists the sessions backend pid is waiting on
+func blockingSessions(ctx context.Context, db *sql.DB, pid int) ([]blocker, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.pid,
+			COALESCE(a.application_name, ''),
+			COALESCE(a.state, ''),
+			COALESCE(a.query, ''),
+			COALESCE(EXTRACT(EPOCH FROM NOW() - a.xact_start), 0)::float8
+		FROM pg_stat_activity a
+		WHERE a.pid = ANY(pg_blocking_pids($1))
+	`, pid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blockers []blocker
+	for rows.Next() {
+		var b blocker
+		var seconds float64
+		if err := rows.Scan(&b.PID, &b.App, &b.State, &b.Query, &seconds); err != nil {
+			return nil, err
+		}
+		b.Duration = time.Duration(seconds * float64(time.Second))
+		blockers = append(blockers, b)
+	}
+	return blockers, rows.Err()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var pgDuration = regexp.MustCompile(`^(\d+)\s*(us|ms|s|min|h|d)$`)
+
+// Parses a timeout written as a Go duration (90s, 1h30m) or with a
+// PostgreSQL unit (30min, 2h, 1d)
+func parseTimeout(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	match := pgDuration.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("invalid timeout %q, expected e.g. 30s, 30min or 2h", s)
+	}
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", s)
+	}
+	unit := map[string]time.Duration{
+		"us": time.Microsecond, "ms": time.Millisecond, "s": time.Second,
+		"min": time.Minute, "h": time.Hour, "d": 24 * time.Hour,
+	}[match[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// Sets statement_timeout and lock_timeout for the rest of a transaction,
+// each only when non-zero
+func setLocalTimeouts(ctx context.Context, ex execer, statement, lock time.Duration) error {
+	if statement > 0 {
+		if _, err := ex.ExecContext(ctx, fmt.Sprintf(`SET LOCAL statement_timeout = %d`, statement.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	if lock > 0 {
+		if _, err := ex.ExecContext(ctx, fmt.Sprintf(`SET LOCAL lock_timeout = %d`, lock.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The --statement-timeout and --lock-timeout values that apply to a
+// migration: a -- statement-timeout directive replaces the first and a lock
+// retry policy, with its own per-attempt timeout, the second. Overridden
+// values are zero.
+func (r *applyRun) defaultTimeouts(m Migration, policy *lockRetryPolicy) (statement, lock time.Duration) {
+	if m.Directives.StatementTimeout == 0 {
+		statement = r.opts.statementTimeout
+	}
+	if policy == nil {
+		lock = r.opts.lockTimeout
+	}
+	return statement, lock
+}
+
+// Runs a statement in a transaction of its own with the given timeouts, as
+// SET LOCAL, and the lock retry policy
+func execInOwnTransaction(ctx context.Context, conn *sql.Conn, stmt string, statement, lock time.Duration, policy *lockRetryPolicy) error {
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	if err := setLocalTimeouts(ctx, tx, statement, lock); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := execWithLockRetry(ctx, tx, true, stmt, policy); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Sets up a statement that runs outside the migration's transaction (ALTER
+// TYPE ... ADD VALUE) to get the default timeouts too. From PostgreSQL 12 on
+// it gets a transaction of its own with SET LOCAL; before, ADD VALUE can't
+// run in a transaction block, so they are set on the session instead. Returns
+// where to run it, whether that is a transaction, and finish, which ends the
+// transaction or resets the session given the statement's error.
+func (r *applyRun) outsideTransaction(ctx context.Context, conn *sql.Conn, m Migration, policy *lockRetryPolicy) (execer, bool, func(error) error, error) {
+	statement, lock := r.defaultTimeouts(m, policy)
+	if r.serverVersion >= 120000 {
+		tx, err := conn.BeginTx(ctx, &sql.TxOptions{})
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if err := setLocalTimeouts(ctx, tx, statement, lock); err != nil {
+			tx.Rollback()
+			return nil, false, nil, err
+		}
+		finish := func(err error) error {
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}
+		return tx, true, finish, nil
+	}
+
+	var resets []string
+	finish := func(err error) error {
+		for _, reset := range resets {
+			conn.ExecContext(context.Background(), reset)
+		}
+		return err
+	}
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{{"statement_timeout", statement}, {"lock_timeout", lock}} {
+		if t.value <= 0 {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf(`SET %s = %d`, t.name, t.value.Milliseconds())); err != nil {
+			return nil, false, nil, finish(err)
+		}
+		resets = append(resets, `RESET `+t.name)
+	}
+	return conn, false, finish, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+)
+
+// Layout of migration versions, same as the Supabase CLI (UTC)
+const versionLayout = "20060102150405"
+
+// Parses a version as a UTC timestamp. Versions that aren't timestamps are
+// valid migrations but can't take part in time-based checks.
+func parseVersionTime(version string) (time.Time, bool) {
+	if len(version) != len(versionLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(versionLayout, version)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Returns the migrations whose version timestamp is later than now. These are
+// almost always typos (a wrong year or month), and they sort after every
+// migration written until that date, which makes ordering confusing later.
+func futureDatedMigrations(migrations []Migration, now time.Time) []Migration {
+	var future []Migration
+	for _, m := range migrations {
+		if t, ok := parseVersionTime(m.Version); ok && t.After(now) {
+			future = append(future, m)
+		}
+	}
+	return future
+}
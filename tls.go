@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Values accepted by sslmode, as in libpq
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Merges the --sslmode, --sslrootcert, --sslcert and --sslkey flags into a
+// connection string, URL or keyword/value form, overriding the same
+// parameters in it. Certificate files must exist.
+func mergeSSLParams(dbURL string, opts options) (string, error) {
+	params := [][2]string{
+		{"sslmode", opts.sslMode},
+		{"sslrootcert", opts.sslRootCert},
+		{"sslcert", opts.sslCert},
+		{"sslkey", opts.sslKey},
+	}
+	if opts.sslMode != "" && !slices.Contains(sslModes, opts.sslMode) {
+		return "", fmt.Errorf("invalid --sslmode %q (expected %s)", opts.sslMode, strings.Join(sslModes, ", "))
+	}
+	for _, p := range params[1:] {
+		if p[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(p[1]); err != nil {
+			return "", fmt.Errorf("--%s: %v", p[0], err)
+		}
+	}
+
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		u, err := url.Parse(dbURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid connection URL: %v", err)
+		}
+		q := u.Query()
+		for _, p := range params {
+			if p[1] != "" {
+				q.Set(p[0], p[1])
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	// Keyword/value form; a later keyword overrides an earlier one
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, p := range params {
+		if p[1] != "" {
+			dbURL += fmt.Sprintf(" %s='%s'", p[0], quote.Replace(p[1]))
+		}
+	}
+	return dbURL, nil
+}
+
+// Merges the TLS flags into the connection strings the other flags name, so
+// --production-url, compare's --a and --b and every --replica-urls entry
+// connect the same way as DATABASE_URL
+func mergeSSLParamsIntoFlags(opts *options) error {
+	for _, u := range []*string{&opts.productionURL, &opts.compareA, &opts.compareB} {
+		if *u == "" {
+			continue
+		}
+		merged, err := mergeSSLParams(*u, *opts)
+		if err != nil {
+			return err
+		}
+		*u = merged
+	}
+
+	var replicas []string
+	for _, u := range strings.Split(opts.replicaURLs, ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		merged, err := mergeSSLParams(u, *opts)
+		if err != nil {
+			return err
+		}
+		replicas = append(replicas, merged)
+	}
+	opts.replicaURLs = strings.Join(replicas, ",")
+	return nil
+}
+
+// Warns when the connection to a host other than this machine will be made
+// without TLS first: sslmode disable or allow, from the connection string or
+// PGSSLMODE
+func warnWithoutTLS(dbURL string) {
+	config, err := pgconn.ParseConfig(dbURL)
+	if err != nil || config.TLSConfig != nil || isLocalHost(config.Host) {
+		return
+	}
+	logWarn("Warning: connecting to %s without TLS; set --sslmode require (or verify-full with --sslrootcert) to encrypt the connection", config.Host)
+}
+
+// Reports whether host is a Unix socket directory or a loopback address
+func isLocalHost(host string) bool {
+	if strings.HasPrefix(host, "/") || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
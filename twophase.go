@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// A constraint added in one step, validating every existing row while the
+// table is locked
+type validatingConstraint struct {
+	Table      string
+	Constraint string
+}
+
+// Returns the constraint a statement adds with validation, if it is a single
+// ALTER TABLE ... ADD CONSTRAINT name CHECK/FOREIGN KEY without NOT VALID
+func validatingConstraintOf(stmt string) (validatingConstraint, bool) {
+	commands := splitCommands(stmt)
+	if len(commands) != 1 {
+		return validatingConstraint{}, false
+	}
+	cmd := commands[0]
+	if !cmd.is(0, "ALTER", "TABLE") || cmd.find(0, "NOT", "VALID") >= 0 {
+		return validatingConstraint{}, false
+	}
+	table, i := readQualifiedName(cmd, cmd.skip(2, "IF", "EXISTS", "ONLY"))
+	if !cmd.is(i, "ADD", "CONSTRAINT") || i+3 >= len(cmd) {
+		return validatingConstraint{}, false
+	}
+	name := cmd[i+2]
+	if !cmd.is(i+3, "CHECK") && !cmd.is(i+3, "FOREIGN", "KEY") {
+		return validatingConstraint{}, false
+	}
+
+	// Only a single subcommand: no top-level comma
+	depth := 0
+	for _, tok := range cmd[i:] {
+		switch tok {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				return validatingConstraint{}, false
+			}
+		}
+	}
+	return validatingConstraint{Table: table, Constraint: name}, true
+}
+
+// Splits a validating ADD CONSTRAINT into the same statement with NOT VALID,
+// which only holds its lock briefly, and the VALIDATE CONSTRAINT to run after
+// the migration commits, which scans the table under a lock that doesn't block
+// reads or writes
+func splitConstraintValidation(stmt string) (string, string, bool) {
+	c, ok := validatingConstraintOf(stmt)
+	if !ok {
+		return "", "", false
+	}
+
+	// Drop trailing line comments and the semicolon, then append NOT VALID
+	// on its own line
+	lines := strings.Split(strings.TrimSpace(stmt), "\n")
+	for len(lines) > 1 && strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), "--") {
+		lines = lines[:len(lines)-1]
+	}
+	body := strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, "\n")), ";")
+	rewritten := body + "\nNOT VALID"
+
+	// Make sure nothing (an inline comment, say) swallowed the addition
+	want := append(migrate.Tokenize(stmt), "NOT", "VALID")
+	if want[len(want)-3] == ";" {
+		want = append(want[:len(want)-3], "NOT", "VALID")
+	}
+	if !slices.Equal(migrate.Tokenize(rewritten), want) {
+		return "", "", false
+	}
+
+	validate := fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", c.Table, c.Constraint)
+	return rewritten, validate, true
+}
+
+// Warns about statements that validate a new constraint while holding the
+// table's lock, suggesting --two-phase-constraints
+func warnValidatingConstraints(migrations []Migration) {
+	for _, m := range migrations {
+		for i, stmt := range m.Statements {
+			if c, ok := validatingConstraintOf(stmt); ok {
+				logWarn("Warning: %s (%s), statement %d: ADD CONSTRAINT %s scans all of %s while holding its lock; pass --two-phase-constraints to add it NOT VALID and validate it after commit",
+					m.Version, m.Name, i+1, c.Constraint, c.Table)
+			}
+		}
+	}
+}
+
+// Runs the statements a rewrite deferred until after a migration's commit
+// (VALIDATE CONSTRAINT and the like), each in its own transaction with the
+// migration's timeouts and lock retry policy
+func runAfterCommit(ctx context.Context, conn *sql.Conn, version string, statements []string, statementTimeout, lockTimeout time.Duration, policy *lockRetryPolicy) error {
+	for _, stmt := range statements {
+		logInfo("Running after commit of %s: %s", version, stmt)
+		if err := execInOwnTransaction(ctx, conn, stmt, statementTimeout, lockTimeout, policy); err != nil {
+			return fmt.Errorf("%s was applied but the follow-up %q failed; fix and run it by hand: %v", version, stmt, err)
+		}
+	}
+	return nil
+}
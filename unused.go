@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// A table, or a column of one, that a migration drops along with its data
+type droppedData struct {
+	Table  string
+	Column string // empty for the whole table
+}
+
+func (o droppedData) String() string {
+	if o.Column == "" {
+		return "table " + o.Table
+	}
+	return "column " + o.Table + "." + o.Column
+}
+
+// Returns the tables and columns the commands of a statement drop
+func droppedDataObjects(stmt string) []droppedData {
+	var dropped []droppedData
+	for _, cmd := range splitCommands(stmt) {
+		switch {
+		case cmd.is(0, "DROP", "TABLE"):
+			for _, t := range commandLockTargets(cmd) {
+				dropped = append(dropped, droppedData{Table: t.Relation})
+			}
+		case cmd.is(0, "ALTER", "TABLE"):
+			table, i := readQualifiedName(cmd, cmd.skip(2, "IF", "EXISTS", "ONLY"))
+			// Subcommands start after the table name and after each
+			// top-level comma
+			depth := 0
+			for start := i; i < len(cmd); i++ {
+				switch cmd[i] {
+				case "(":
+					depth++
+				case ")":
+					depth--
+				case ",":
+					if depth == 0 {
+						start = i + 1
+					}
+				}
+				if i != start || !cmd.is(i, "DROP") || cmd.is(i+1, "CONSTRAINT") {
+					continue
+				}
+				col := cmd.skip(cmd.skip(i+1, "COLUMN"), "IF", "EXISTS")
+				if col < len(cmd) && (migrate.IsWordByte(cmd[col][0]) || cmd[col][0] == '"') {
+					dropped = append(dropped, droppedData{Table: table, Column: unquoteIdent(cmd[col])})
+				}
+			}
+		}
+	}
+	return dropped
+}
+
+// What one database's statistics say about the use of a dropped object
+type usageEvidence struct {
+	Source  string
+	Verdict string // "unused", "used" or "unknown"
+	Detail  string
+}
+
+// Looks for evidence that an object was read or written in the last days,
+// in the database's statistics: scan and row counters of pg_stat_user_tables
+// (with PostgreSQL 16's last scan times) for tables, and the statements
+// pg_stat_statements saw mentioning a column for columns. Counters can't be
+// dated, so they only prove the object unused when they are zero and only
+// prove it used when the statistics were reset within the window; otherwise
+// the verdict is unknown. On a replica only reads are counted.
+func objectUsage(ctx context.Context, db *sql.DB, obj droppedData, days int) (usageEvidence, error) {
+	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	if obj.Column != "" {
+		return columnUsage(ctx, db, obj, cutoff)
+	}
+
+	var reads, writes int64
+	var lastRead, reset sql.NullTime
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(s.seq_scan, 0) + COALESCE(s.idx_scan, 0),
+			COALESCE(s.n_tup_ins + s.n_tup_upd + s.n_tup_del, 0),
+			GREATEST((to_jsonb(s)->>'last_seq_scan')::timestamptz, (to_jsonb(s)->>'last_idx_scan')::timestamptz),
+			d.stats_reset,
+			s.relid IS NOT NULL
+		FROM pg_stat_database d
+		LEFT JOIN pg_stat_user_tables s ON s.relid = to_regclass($1)
+		WHERE d.datname = current_database()
+	`, obj.Table).Scan(&reads, &writes, &lastRead, &reset, &exists)
+	if err != nil {
+		return usageEvidence{}, err
+	}
+	if !exists {
+		return usageEvidence{Verdict: "unknown", Detail: "no statistics (table not found)"}, nil
+	}
+
+	window := "since statistics began"
+	if reset.Valid {
+		window = "since statistics were reset " + reset.Time.UTC().Format(time.DateOnly)
+	}
+	detail := fmt.Sprintf("%s scans, %s rows written %s", formatCount(reads), formatCount(writes), window)
+	if lastRead.Valid {
+		detail += ", last read " + lastRead.Time.UTC().Format(time.DateTime)
+	}
+	windowCovers := !reset.Valid || reset.Time.Before(cutoff)
+	switch {
+	case lastRead.Valid && lastRead.Time.After(cutoff):
+		return usageEvidence{Verdict: "used", Detail: detail}, nil
+	case reads+writes > 0 && !windowCovers:
+		return usageEvidence{Verdict: "used", Detail: detail}, nil
+	case writes == 0 && (reads == 0 || lastRead.Valid) && windowCovers:
+		return usageEvidence{Verdict: "unused", Detail: detail}, nil
+	}
+	return usageEvidence{Verdict: "unknown", Detail: detail}, nil
+}
+
+// Counts the calls pg_stat_statements recorded for statements naming both
+// the column and its table, other than DDL
+func columnUsage(ctx context.Context, db *sql.DB, obj droppedData, cutoff time.Time) (usageEvidence, error) {
+	var schema sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname = 'pg_stat_statements'
+	`).Scan(&schema)
+	if err == sql.ErrNoRows {
+		return usageEvidence{Verdict: "unknown", Detail: "pg_stat_statements is not installed"}, nil
+	}
+	if err != nil {
+		return usageEvidence{}, err
+	}
+	ext := quoteIdentIfNeeded(schema.String)
+
+	parts := strings.Split(obj.Table, ".")
+	table := unquoteIdent(parts[len(parts)-1])
+	word := func(name string) string { return `\m` + regexp.QuoteMeta(name) + `\M` }
+	var calls int64
+	err = db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT COALESCE(SUM(calls), 0)::bigint FROM %s.pg_stat_statements
+		WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+			AND query ~* $1 AND query ~* $2
+			AND query !~* '^\s*(ALTER|DROP|CREATE|COMMENT)\M'
+	`, ext), word(obj.Column), word(table)).Scan(&calls)
+	if err != nil {
+		return usageEvidence{}, err
+	}
+
+	// pg_stat_statements_info, with the reset time, exists from 1.9 on
+	var reset sql.NullTime
+	db.QueryRowContext(ctx, fmt.Sprintf(`SELECT stats_reset FROM %s.pg_stat_statements_info`, ext)).Scan(&reset)
+	window := "since pg_stat_statements began"
+	if reset.Valid {
+		window = "since pg_stat_statements was reset " + reset.Time.UTC().Format(time.DateOnly)
+	}
+	detail := fmt.Sprintf("%s calls of statements naming it %s", formatCount(calls), window)
+	windowCovers := !reset.Valid || reset.Time.Before(cutoff)
+	switch {
+	case calls > 0 && !windowCovers:
+		return usageEvidence{Verdict: "used", Detail: detail}, nil
+	case calls == 0 && windowCovers:
+		return usageEvidence{Verdict: "unused", Detail: detail}, nil
+	}
+	return usageEvidence{Verdict: "unknown", Detail: detail}, nil
+}
+
+// Gathers the usage evidence of an object from the primary and each replica,
+// since reads served by replicas never show up in the primary's statistics.
+// The object is unused only if every database says so, and used if any
+// does.
+func gatherUsage(ctx context.Context, primary *sql.DB, replicas map[string]*sql.DB, obj droppedData, days int) (string, []usageEvidence) {
+	sources := append([]string{"primary"}, slices.Sorted(maps.Keys(replicas))...)
+	dbs := maps.Clone(replicas)
+	dbs["primary"] = primary
+	verdict := "unused"
+	var evidence []usageEvidence
+	for _, source := range sources {
+		e, err := objectUsage(ctx, dbs[source], obj, days)
+		if err != nil {
+			e = usageEvidence{Verdict: "unknown", Detail: fmt.Sprintf("could not read statistics: %v", err)}
+		}
+		e.Source = source
+		evidence = append(evidence, e)
+		switch {
+		case e.Verdict == "used":
+			verdict = "used"
+		case e.Verdict == "unknown" && verdict == "unused":
+			verdict = "unknown"
+		}
+	}
+	return verdict, evidence
+}
+
+// Opens the --replica-urls databases, by host
+func openReplicas(urls string) (map[string]*sql.DB, error) {
+	replicas := map[string]*sql.DB{}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		name := fmt.Sprintf("replica %d", len(replicas)+1)
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			name = "replica " + parsed.Host
+		}
+		warnWithoutTLS(u)
+		db, err := sql.Open("pgx", u)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, err
+		}
+		replicas[name] = db
+	}
+	return replicas, nil
+}
+
+func closeReplicas(replicas map[string]*sql.DB) {
+	for _, db := range replicas {
+		db.Close()
+	}
+}
+
+// Logs the usage evidence of each table and column the migration drops and
+// returns how many are used and how many couldn't be shown unused
+func reportUnused(ctx context.Context, db *sql.DB, replicas map[string]*sql.DB, m Migration, days int) (used, unknown int) {
+	for _, stmt := range m.Statements {
+		for _, obj := range droppedDataObjects(stmt) {
+			verdict, evidence := gatherUsage(ctx, db, replicas, obj, days)
+			logEvent(levelInfo, "drop_usage", logFields{"version": m.Version, "object": obj.String(), "verdict": verdict, "days": days},
+				"  %s drops %s: %s in the last %d days", m.Version, obj, verdict, days)
+			for _, e := range evidence {
+				logInfo("    %s: %s", e.Source, e.Detail)
+			}
+			switch verdict {
+			case "used":
+				used++
+			case "unknown":
+				unknown++
+			}
+		}
+	}
+	return used, unknown
+}
+
+// Checks before applying that the tables and columns the pending migrations
+// drop had no reads or writes in the last --unused-days days, on the primary
+// and the --replica-urls replicas. Fails if one was used; objects whose use
+// can't be ruled out are warned about, or fail with --strict.
+func checkUnused(ctx context.Context, db *sql.DB, opts options, pending []Migration) error {
+	replicas, err := openReplicas(opts.replicaURLs)
+	if err != nil {
+		return err
+	}
+	defer closeReplicas(replicas)
+
+	used, unknown := 0, 0
+	for _, m := range pending {
+		u, n := reportUnused(ctx, db, replicas, m, opts.unusedDays)
+		used, unknown = used+u, unknown+n
+	}
+	if used > 0 {
+		return fmt.Errorf("%d dropped table(s) or column(s) were used in the last %d days (--unused-days)", used, opts.unusedDays)
+	}
+	if unknown > 0 {
+		logWarn("Warning: %d dropped table(s) or column(s) could not be shown unused in the last %d days", unknown, opts.unusedDays)
+		if opts.strict {
+			return fmt.Errorf("%d dropped table(s) or column(s) could not be shown unused (--strict)", unknown)
+		}
+	}
+	return nil
+}
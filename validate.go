@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DaviSMoura/supabase-direct-migrate/migrate"
+)
+
+// Words a top-level SQL command can start with
+var sqlCommands = map[string]bool{
+	"ABORT": true, "ALTER": true, "ANALYZE": true, "BEGIN": true, "CALL": true,
+	"CHECKPOINT": true, "CLOSE": true, "CLUSTER": true, "COMMENT": true, "COMMIT": true,
+	"COPY": true, "CREATE": true, "DEALLOCATE": true, "DECLARE": true, "DELETE": true,
+	"DISCARD": true, "DO": true, "DROP": true, "END": true, "EXECUTE": true,
+	"EXPLAIN": true, "FETCH": true, "GRANT": true, "IMPORT": true, "INSERT": true,
+	"LISTEN": true, "LOAD": true, "LOCK": true, "MERGE": true, "MOVE": true,
+	"NOTIFY": true, "PREPARE": true, "REASSIGN": true, "REFRESH": true, "REINDEX": true,
+	"RELEASE": true, "RESET": true, "REVOKE": true, "ROLLBACK": true, "SAVEPOINT": true,
+	"SECURITY": true, "SELECT": true, "SET": true, "SHOW": true, "START": true,
+	"TABLE": true, "TRUNCATE": true, "UNLISTEN": true, "UPDATE": true, "VACUUM": true,
+	"VALUES": true, "WITH": true,
+}
+
+// Object kinds that can follow CREATE, ALTER and DROP
+var sqlObjectKinds = map[string]bool{
+	"ACCESS": true, "AGGREGATE": true, "CAST": true, "COLLATION": true, "CONSTRAINT": true,
+	"CONVERSION": true, "DATABASE": true, "DEFAULT": true, "DOMAIN": true, "EVENT": true,
+	"EXTENSION": true, "FOREIGN": true, "FUNCTION": true, "GROUP": true, "INDEX": true,
+	"LANGUAGE": true, "LARGE": true, "MATERIALIZED": true, "OPERATOR": true, "OWNED": true,
+	"POLICY": true, "PROCEDURE": true, "PUBLICATION": true, "ROLE": true, "ROUTINE": true,
+	"RULE": true, "SCHEMA": true, "SEQUENCE": true, "SERVER": true, "STATISTICS": true,
+	"SUBSCRIPTION": true, "SYSTEM": true, "TABLE": true, "TABLESPACE": true, "TEXT": true,
+	"TRANSFORM": true, "TRIGGER": true, "TYPE": true, "USER": true, "VIEW": true,
+}
+
+// Modifiers that can come between CREATE and the object kind
+var sqlCreateModifiers = map[string]bool{
+	"OR": true, "REPLACE": true, "UNIQUE": true, "TEMP": true, "TEMPORARY": true,
+	"UNLOGGED": true, "GLOBAL": true, "LOCAL": true, "RECURSIVE": true,
+	"TRUSTED": true, "PROCEDURAL": true,
+}
+
+// How many leading words of a command are kept for checking; enough to get
+// past CREATE OR REPLACE TEMP
+const maxLeadingWords = 6
+
+// Checks every local migration for syntax problems without connecting to a
+// database: the lexical pass always, then PostgreSQL's parser on statements
+// that pass it when built with -tags pg_query. Returns false if any were
+// found.
+func runValidate(opts options) bool {
+	started := time.Now()
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		logError("Error: %v", err)
+		return false
+	}
+
+	count := 0
+	for _, m := range migrations {
+		for i, stmt := range m.Statements {
+			problems := sqlSyntaxProblems(stmt)
+			if len(problems) == 0 {
+				problems = parserSyntaxProblems(stmt)
+			}
+			for _, p := range problems {
+				count++
+				if len(m.Statements) > 1 {
+					logError("%s_%s: statement %d: %s", m.Version, m.Name, i+1, p)
+				} else {
+					logError("%s_%s: %s", m.Version, m.Name, p)
+				}
+			}
+		}
+	}
+	if count > 0 {
+		logError("%d syntax problem(s) found in %d migrations.", count, len(migrations))
+		return false
+	}
+
+	if validateParser != "" {
+		logInfo("%d migrations validated with %s in %s.", len(migrations), validateParser, time.Since(started).Round(time.Millisecond))
+	} else {
+		logInfo("%d migrations validated in %s.", len(migrations), time.Since(started).Round(time.Millisecond))
+	}
+	return true
+}
+
+// Returns the syntax problems a lexical pass can find in SQL: unterminated
+// strings, quoted identifiers, dollar quotes and comments, unbalanced
+// parentheses, and commands or object kinds PostgreSQL doesn't know (usually
+// typos like CRAETE TABLE). It doesn't check the full grammar.
+func sqlSyntaxProblems(sql string) []string {
+	var problems []string
+	var words []string // leading words of the current command
+	depth, depthLine := 0, 0
+	line := 1
+
+	endCommand := func() {
+		if p := commandProblem(words); p != "" {
+			problems = append(problems, p)
+		}
+		words = nil
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				i = len(sql)
+				continue
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := migrate.SkipBlockComment(sql, i)
+			if end == len(sql) && (end-i < 4 || !strings.HasSuffix(sql, "*/")) {
+				problems = append(problems, fmt.Sprintf("line %d: unterminated /* comment", line))
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end
+		case c == '\'' || c == '"':
+			end, closed := migrate.SkipQuoted(sql, i)
+			if !closed {
+				what := "string literal"
+				if c == '"' {
+					what = "quoted identifier"
+				}
+				problems = append(problems, fmt.Sprintf("line %d: unterminated %s", line, what))
+				return problems
+			}
+			if len(words) < maxLeadingWords {
+				words = append(words, sql[i:end])
+			}
+			line += strings.Count(sql[i:end], "\n")
+			i = end
+		case c == '$':
+			tag, ok := migrate.DollarQuoteTag(sql[i:])
+			if !ok {
+				i++
+				continue
+			}
+			closing := strings.Index(sql[i+len(tag):], tag)
+			if closing < 0 {
+				problems = append(problems, fmt.Sprintf("line %d: unterminated dollar-quoted string %s", line, tag))
+				return problems
+			}
+			end := i + len(tag) + closing + len(tag)
+			line += strings.Count(sql[i:end], "\n")
+			i = end
+		case c == '(':
+			if depth == 0 {
+				depthLine = line
+			}
+			depth++
+			if len(words) == 0 {
+				words = append(words, "(")
+			}
+			i++
+		case c == ')':
+			if depth == 0 {
+				problems = append(problems, fmt.Sprintf("line %d: unexpected )", line))
+			} else {
+				depth--
+			}
+			i++
+		case c == ';':
+			if depth > 0 {
+				problems = append(problems, fmt.Sprintf("line %d: unclosed ( before end of statement", depthLine))
+				depth = 0
+			}
+			endCommand()
+			i++
+		case migrate.IsWordByte(c):
+			end := i + 1
+			for end < len(sql) && (migrate.IsWordByte(sql[end]) || sql[end] == '$') {
+				end++
+			}
+			if len(words) < maxLeadingWords {
+				words = append(words, sql[i:end])
+			}
+			i = end
+		default:
+			if len(words) > 0 && len(words) < maxLeadingWords {
+				// Punctuation ends the leading words
+				words = append(words, string(c))
+			}
+			i++
+		}
+	}
+
+	if depth > 0 {
+		problems = append(problems, fmt.Sprintf("line %d: unclosed (", depthLine))
+	}
+	endCommand()
+	return problems
+}
+
+// Checks the first words of a command: the command itself and, for CREATE,
+// ALTER and DROP, the kind of object
+func commandProblem(words []string) string {
+	if len(words) == 0 || words[0] == "(" {
+		return ""
+	}
+	first := strings.ToUpper(words[0])
+	if !sqlCommands[first] {
+		return unknownWord("command", words[0], sqlCommands)
+	}
+	if first != "CREATE" && first != "ALTER" && first != "DROP" {
+		return ""
+	}
+
+	rest := words[1:]
+	for len(rest) > 0 && first == "CREATE" && sqlCreateModifiers[strings.ToUpper(rest[0])] {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || !migrate.IsWordByte(rest[0][0]) {
+		return ""
+	}
+	if !sqlObjectKinds[strings.ToUpper(rest[0])] {
+		return unknownWord(fmt.Sprintf("object type after %s", first), rest[0], sqlObjectKinds)
+	}
+	return ""
+}
+
+// Formats an unknown keyword, suggesting the closest known one
+func unknownWord(what, word string, known map[string]bool) string {
+	best, bestDist := "", 3
+	for k := range known {
+		if d := editDistance(strings.ToUpper(word), k); d < bestDist || (d == bestDist && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("unknown %s %s (did you mean %s?)", what, word, best)
+	}
+	return fmt.Sprintf("unknown %s %s", what, word)
+}
+
+// Levenshtein distance, counting an adjacent transposition as one edit
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged context kept around each change in a diff
+const diffContext = 2
+
+// Returns the applied migrations whose local file no longer has the recorded
+// hash
+func changedSinceApplied(migrations []Migration, applied map[string]string) []Migration {
+	var changed []Migration
+	for _, m := range migrations {
+		if hash, ok := applied[m.Version]; ok && !m.Directives.RunAlways && hash != m.Hash {
+			changed = append(changed, m)
+		}
+	}
+	return changed
+}
+
+// Returns the statements recorded for a version, decrypted when needed; nil
+// when they weren't stored (baseline rows, --no-store-statements) or can't be
+// decrypted
+func recordedStatements(ctx context.Context, db *sql.DB, key []byte, version string) []string {
+	var statementsJSON string
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT to_json(statements)::text FROM %s.%s WHERE version = $1
+	`, schemaName, tableName), version).Scan(&statementsJSON)
+	if err != nil {
+		return nil
+	}
+	var stored []string
+	if json.Unmarshal([]byte(statementsJSON), &stored) != nil {
+		return nil
+	}
+	statements := make([]string, len(stored))
+	for i, s := range stored {
+		if statements[i], err = decryptStatement(key, s); err != nil {
+			return nil
+		}
+	}
+	return statements
+}
+
+// Diffs two texts line by line, with - for removed and + for added lines and
+// diffContext unchanged lines around each change
+func lineDiff(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// Longest common subsequence table, from the end
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []string
+	for i, j := 0, 0; i < len(x) || j < len(y); {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, "  "+x[i])
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, "+ "+y[j])
+			j++
+		default:
+			lines = append(lines, "- "+x[i])
+			i++
+		}
+	}
+
+	// Keep only the changes and their context
+	keep := make([]bool, len(lines))
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "  ") {
+			for k := max(0, i-diffContext); k <= min(len(lines)-1, i+diffContext); k++ {
+				keep[k] = true
+			}
+		}
+	}
+	var out []string
+	for i, l := range lines {
+		if keep[i] {
+			out = append(out, l)
+		} else if i > 0 && keep[i-1] {
+			out = append(out, "  ...")
+		}
+	}
+	return out
+}
+
+// Fails when an applied migration's file changed since it was applied, with a
+// diff between the recorded statements and the file's where they were stored
+func verifyApplied(ctx context.Context, db *sql.DB, migrations []Migration, applied map[string]string) error {
+	changed := changedSinceApplied(migrations, applied)
+	if len(changed) == 0 {
+		return nil
+	}
+	key, _ := loadHistoryKey()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d applied migration(s) changed since they were applied:", len(changed))
+	for _, m := range changed {
+		fmt.Fprintf(&b, "\n\n%s (%s): recorded hash %s, file hash %s", m.Version, m.Name, shortHash(applied[m.Version]), shortHash(m.Hash))
+		recorded := recordedStatements(ctx, db, key, m.Version)
+		if recorded == nil {
+			b.WriteString("\n  (the applied statements weren't stored, so there is no diff)")
+			continue
+		}
+		const sep = "\n-- statement-breakpoint\n"
+		for _, line := range lineDiff(strings.Join(recorded, sep), strings.Join(m.Statements, sep)) {
+			b.WriteString("\n  " + line)
+		}
+	}
+	b.WriteString("\n\nRestore the files, or put the change in a new migration; --skip-verify applies anyway")
+	return &driftError{msg: b.String()}
+}
+
+// Handles `verify`: checks that no applied migration's file changed since it
+// was applied. Returns false if one did.
+func runVerify(dbURL string) (bool, error) {
+	ctx := context.Background()
+	db, err := sql.Open("pgx", dbURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	applied, err := fetchAppliedMigrations(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	migrations, err := loadLocalMigrations()
+	if err != nil {
+		return false, err
+	}
+	if err := verifyApplied(ctx, db, migrations, applied); err != nil {
+		logError("%v", err)
+		return false, nil
+	}
+	logInfo("All %d applied migrations match their files.", len(applied))
+	return true, nil
+}